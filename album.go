@@ -0,0 +1,172 @@
+package tgfun
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	minAlbumItems = 2
+	maxAlbumItems = 10
+)
+
+// AlbumItem - photo or video of the media group
+type AlbumItem struct {
+	Image   string `json:"image"`   // local filename or URL
+	Video   string `json:"video"`   // local filename or URL
+	Caption string `json:"caption"` // optional
+	// optional. language -> caption.
+	// Caption in default language is sent when translation is not set
+	Translations map[string]string `json:"translations"`
+}
+
+func (item AlbumItem) getType() MessageType {
+	if item.Video != "" {
+		return MessageTypeVideo
+	}
+	return MessageTypePhoto
+}
+
+func (item AlbumItem) getPath() string {
+	if item.Video != "" {
+		return item.Video
+	}
+	return item.Image
+}
+
+func isExternalFile(path string) bool {
+	return strings.Contains(path, "http")
+}
+
+// albums can't carry keyboards, so message text and buttons
+// are sent in a follow-up message
+func isAlbumWithFollowUp(msg EventMessage) bool {
+	return len(msg.Buttons) > 0 || msg.Keyboard == KeyboardRemove
+}
+
+// returns album, state. plain text is returned when there are not enough files
+func (q *QueryHandler) getAlbumMessage(
+	message EventMessage,
+	filesRoot string,
+) (interface{}, fileState) {
+	st := fileState{Type: MessageTypeAlbum}
+
+	var album tb.Album
+	for _, item := range message.Album {
+		path := item.getPath()
+		itemState := fileState{Type: item.getType()}
+
+		var file tb.File
+		if isExternalFile(path) {
+			file = tb.FromURL(path)
+		} else {
+			localFile, localSt, isExists := q.getLocalFile(path, filesRoot, item.getType())
+			if !isExists {
+				continue // skip item
+			}
+
+			file = localFile
+			itemState = localSt
+		}
+
+		caption := item.Caption
+		if len(album) == 0 && caption == "" && !isAlbumWithFollowUp(message) {
+			caption = message.Text
+		}
+
+		if item.getType() == MessageTypeVideo {
+			album = append(album, &tb.Video{File: file, Caption: caption})
+		} else {
+			album = append(album, &tb.Photo{File: file, Caption: caption})
+		}
+		st.Album = append(st.Album, itemState)
+		st.IsUsed = st.IsUsed || itemState.IsUsed
+	}
+
+	if len(album) < minAlbumItems {
+		return getTextMessage(message), fileState{} // use plain text, when files not exists
+	}
+	return album, st
+}
+
+// sends album and follow-up message with keyboard.
+// returns the first album message
+func (q *QueryHandler) sendAlbum(
+	chatID int64,
+	album tb.Album,
+	args ...interface{},
+) (*tb.Message, error) {
+	responses, err := q.Bot.SendAlbum(tb.ChatID(chatID), album, args...)
+	if err != nil {
+		return nil, fmt.Errorf("send album: %w", err)
+	}
+	if len(responses) == 0 {
+		return nil, errors.New("send album: empty response")
+	}
+	q.albumMessages = responses
+
+	if isAlbumWithFollowUp(q.EventData.Message) && q.EventData.Message.Text != "" {
+		args = append(args, q.Menu)
+		if _, err := q.Bot.Send(tb.ChatID(chatID), q.EventData.Message.Text, args...); err != nil {
+			return nil, fmt.Errorf("send album buttons: %w", err)
+		}
+	}
+	return &responses[0], nil
+}
+
+func (q *QueryHandler) actualizeAlbumCache(st fileState) {
+	for i, itemState := range st.Album {
+		if i >= len(q.albumMessages) {
+			return
+		}
+		q.ActualizeCache(itemState, &q.albumMessages[i])
+	}
+}
+
+func (f *Funnel) validateAlbum(r *ValidationResult, eventID string, msg EventMessage) {
+	if len(msg.Album) == 0 {
+		return
+	}
+
+	if len(msg.Album) < minAlbumItems || len(msg.Album) > maxAlbumItems {
+		r.addError(
+			eventID, "message.album",
+			"album must contain from %v to %v items, got %v",
+			minAlbumItems, maxAlbumItems, len(msg.Album),
+		)
+	}
+	if isAlbumWithFollowUp(msg) && msg.Text == "" {
+		r.addError(eventID, "message.text", "text is required to send album buttons")
+	}
+
+	for i, item := range msg.Album {
+		field := fmt.Sprintf("message.album[%v]", i)
+
+		switch {
+		case item.Image == "" && item.Video == "":
+			r.addError(eventID, field, "neither image nor video is set")
+			continue
+		case item.Image != "" && item.Video != "":
+			r.addError(eventID, field, "both image and video are set, image will be ignored")
+		}
+
+		pathField := field + ".image"
+		if item.getType() == MessageTypeVideo {
+			pathField = field + ".video"
+		}
+		if !isExternalFile(item.getPath()) {
+			f.validateLocalFile(r, eventID, pathField, item.getPath())
+		}
+		if length := utf8.RuneCountInString(item.Caption); length > maxCaptionLength {
+			r.addError(
+				eventID, field+".caption",
+				"caption length %v exceeds telegram limit of %v characters",
+				length, maxCaptionLength,
+			)
+		}
+	}
+}
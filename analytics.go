@@ -0,0 +1,329 @@
+package tgfun
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// AnalyticsEventKind - kind of event log record
+type AnalyticsEventKind string
+
+const (
+	AnalyticsEventDelivery   AnalyticsEventKind = "delivery"   // event sent to user
+	AnalyticsEventClick      AnalyticsEventKind = "click"      // button pressed
+	AnalyticsEventConversion AnalyticsEventKind = "conversion" // event with conversion tag sent
+)
+
+// AnalyticsEvent - event log record
+type AnalyticsEvent struct {
+	TelegramUserID int64
+	Kind           AnalyticsEventKind
+	EventID        string // delivered event or button target event
+	Button         string // pressed button text
+	Conversion     string // conversion tag
+	UTMSource      string // set for events opened by link with UTM tags
+	UTMCampaign    string
+	CreatedAt      time.Time
+}
+
+// AnalyticsFeature - record event deliveries and button clicks
+// to build funnel reports with Funnel.GetFunnelReport
+type AnalyticsFeature struct {
+	// required
+	Store AnalyticsStore
+}
+
+func (f *Funnel) EnableAnalyticsFeature(feature AnalyticsFeature) error {
+	if feature.Store == nil {
+		return errors.New("analytics store is not set")
+	}
+
+	f.features.Analytics = &feature
+	return nil
+}
+
+func (f *funnelFeatures) IsAnalyticsFeatureActive() bool {
+	return f.Analytics != nil
+}
+
+func (a *AnalyticsFeature) record(event AnalyticsEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	if err := a.Store.SaveAnalyticsEvent(event); err != nil {
+		return fmt.Errorf("save %s: %w", event.Kind, err)
+	}
+	return nil
+}
+
+// records delivery and conversion tags of the event
+func (q *QueryHandler) recordDelivery(telegramUserID int64, eventID string, event FunnelEvent) {
+	now := time.Now()
+	delivery := AnalyticsEvent{
+		TelegramUserID: telegramUserID,
+		Kind:           AnalyticsEventDelivery,
+		EventID:        eventID,
+		CreatedAt:      now,
+	}
+	// backlink payload, e.g. offer_back, is not a UTM tag
+	if q.payload.hasUTM() {
+		delivery.UTMSource = q.payload.UTMSource
+		delivery.UTMCampaign = q.payload.UTMCampaign
+	}
+	if err := q.Features.Analytics.record(delivery); err != nil {
+		q.reportError(ErrorStageAnalytics, err)
+	}
+
+	for _, conversion := range event.Message.getConversions() {
+		err := q.Features.Analytics.record(AnalyticsEvent{
+			TelegramUserID: telegramUserID,
+			Kind:           AnalyticsEventConversion,
+			EventID:        eventID,
+			Conversion:     conversion,
+			CreatedAt:      now,
+		})
+		if err != nil {
+			q.reportError(ErrorStageAnalytics, err)
+		}
+	}
+}
+
+// count button click in metrics and analytics.
+// buttonLabel is static metrics label, buttonText is text seen by user
+func (f *Funnel) onButtonClick(ctx tb.Context, eventID, buttonLabel, buttonText string) {
+	f.features.getMetrics().onButtonClick(eventID, buttonLabel)
+	if !f.features.IsAnalyticsFeatureActive() || ctx.Sender() == nil {
+		return
+	}
+
+	err := f.features.Analytics.record(AnalyticsEvent{
+		TelegramUserID: ctx.Sender().ID,
+		Kind:           AnalyticsEventClick,
+		EventID:        eventID,
+		Button:         buttonText,
+	})
+	if err != nil {
+		f.errReporter.report(ErrorEvent{
+			UserID:  ctx.Sender().ID,
+			EventID: eventID,
+			Stage:   ErrorStageAnalytics,
+			Err:     err,
+		})
+	}
+}
+
+// FunnelReportOptions - funnel report period and steps
+type FunnelReportOptions struct {
+	// optional. events of the period [From, To) are used
+	From time.Time
+	To   time.Time
+	// optional. conversion tags in funnel order.
+	// default: all tags, the most reached first
+	Conversions []string
+}
+
+// FunnelReport - drop-off from /start to conversions
+type FunnelReport struct {
+	Total   CohortReport
+	Cohorts []CohortReport // by UTM tags of the first link, the largest first
+}
+
+// CohortReport - funnel steps of users with the same UTM tags.
+// Users without tags are in the cohort with empty tags
+type CohortReport struct {
+	UTMSource   string
+	UTMCampaign string
+	Users       int // users, who started the funnel
+	Steps       []FunnelStepReport
+}
+
+// FunnelStepReport - users, who reached the conversion after the previous step
+type FunnelStepReport struct {
+	Conversion string
+	Users      int
+	Rate       float64 // part of started users
+	StepRate   float64 // part of users of the previous step
+	// from /start to conversion
+	MedianTimeToConvert time.Duration
+}
+
+// GetFunnelReport - build funnel report from analytics event log
+func (f *Funnel) GetFunnelReport(opts FunnelReportOptions) (FunnelReport, error) {
+	if !f.features.IsAnalyticsFeatureActive() {
+		return FunnelReport{}, errors.New("analytics feature is disabled")
+	}
+
+	events, err := f.features.Analytics.Store.ListAnalyticsEvents(opts.From, opts.To)
+	if err != nil {
+		return FunnelReport{}, fmt.Errorf("list analytics events: %w", err)
+	}
+	return buildFunnelReport(events, opts.Conversions), nil
+}
+
+type reportCohort struct {
+	utmSource   string
+	utmCampaign string
+}
+
+// funnel path of one user
+type userJourney struct {
+	cohort      reportCohort
+	hasCohort   bool
+	startAt     time.Time
+	conversions map[string]time.Time // first conversion after start
+}
+
+// events must be sorted by time
+func buildFunnelReport(events []AnalyticsEvent, conversions []string) FunnelReport {
+	journeys := map[int64]*userJourney{}
+	var userIDs []int64
+	for _, event := range events {
+		j, isExists := journeys[event.TelegramUserID]
+		if !isExists {
+			j = &userJourney{conversions: map[string]time.Time{}}
+			journeys[event.TelegramUserID] = j
+			userIDs = append(userIDs, event.TelegramUserID)
+		}
+
+		if !j.hasCohort && (event.UTMSource != "" || event.UTMCampaign != "") {
+			j.cohort = reportCohort{utmSource: event.UTMSource, utmCampaign: event.UTMCampaign}
+			j.hasCohort = true
+		}
+
+		switch {
+		case event.Kind == AnalyticsEventDelivery && event.EventID == startMessageCode:
+			if j.startAt.IsZero() {
+				j.startAt = event.CreatedAt
+			}
+		case event.Kind == AnalyticsEventConversion && !j.startAt.IsZero():
+			if _, isConverted := j.conversions[event.Conversion]; !isConverted {
+				j.conversions[event.Conversion] = event.CreatedAt
+			}
+		}
+	}
+
+	var started []*userJourney
+	for _, userID := range userIDs {
+		if j := journeys[userID]; !j.startAt.IsZero() {
+			started = append(started, j)
+		}
+	}
+	if len(conversions) == 0 {
+		conversions = getReachedConversions(started)
+	}
+
+	report := FunnelReport{Total: buildCohortReport(reportCohort{}, started, conversions)}
+
+	cohortUsers := map[reportCohort][]*userJourney{}
+	for _, j := range started {
+		cohortUsers[j.cohort] = append(cohortUsers[j.cohort], j)
+	}
+	for cohort, users := range cohortUsers {
+		report.Cohorts = append(report.Cohorts, buildCohortReport(cohort, users, conversions))
+	}
+	sort.Slice(report.Cohorts, func(i, j int) bool {
+		a, b := report.Cohorts[i], report.Cohorts[j]
+		if a.Users != b.Users {
+			return a.Users > b.Users
+		}
+		if a.UTMSource != b.UTMSource {
+			return a.UTMSource < b.UTMSource
+		}
+		return a.UTMCampaign < b.UTMCampaign
+	})
+	return report
+}
+
+// returns conversion tags, the most reached first
+func getReachedConversions(journeys []*userJourney) []string {
+	usersCount := map[string]int{}
+	for _, j := range journeys {
+		for conversion := range j.conversions {
+			usersCount[conversion]++
+		}
+	}
+
+	conversions := make([]string, 0, len(usersCount))
+	for conversion := range usersCount {
+		conversions = append(conversions, conversion)
+	}
+	sort.Slice(conversions, func(i, j int) bool {
+		a, b := conversions[i], conversions[j]
+		if usersCount[a] != usersCount[b] {
+			return usersCount[a] > usersCount[b]
+		}
+		return a < b
+	})
+	return conversions
+}
+
+func buildCohortReport(
+	cohort reportCohort,
+	journeys []*userJourney,
+	conversions []string,
+) CohortReport {
+	report := CohortReport{
+		UTMSource:   cohort.utmSource,
+		UTMCampaign: cohort.utmCampaign,
+		Users:       len(journeys),
+	}
+
+	// users of the previous step and time they reached it
+	reached := make(map[*userJourney]time.Time, len(journeys))
+	for _, j := range journeys {
+		reached[j] = j.startAt
+	}
+
+	previousUsers := len(journeys)
+	for _, conversion := range conversions {
+		step := FunnelStepReport{Conversion: conversion}
+		nextReached := map[*userJourney]time.Time{}
+		var durations []time.Duration
+
+		for j, reachedAt := range reached {
+			convertedAt, isConverted := j.conversions[conversion]
+			if !isConverted || convertedAt.Before(reachedAt) {
+				continue
+			}
+
+			nextReached[j] = convertedAt
+			durations = append(durations, convertedAt.Sub(j.startAt))
+		}
+
+		step.Users = len(nextReached)
+		step.Rate = getRate(step.Users, report.Users)
+		step.StepRate = getRate(step.Users, previousUsers)
+		step.MedianTimeToConvert = getMedianDuration(durations)
+		report.Steps = append(report.Steps, step)
+
+		reached = nextReached
+		previousUsers = step.Users
+	}
+	return report
+}
+
+func getRate(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) / float64(total)
+}
+
+func getMedianDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	middle := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[middle-1] + durations[middle]) / 2
+	}
+	return durations[middle]
+}
@@ -0,0 +1,152 @@
+package tgfun
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AnalyticsStore - funnel event log storage backend
+type AnalyticsStore interface {
+	SaveAnalyticsEvent(event AnalyticsEvent) error
+	// returns events created in [from, to), earliest first.
+	// zero time means no bound
+	ListAnalyticsEvents(from, to time.Time) ([]AnalyticsEvent, error)
+}
+
+// MemoryAnalyticsStore - in-memory event log, lost on restart
+type MemoryAnalyticsStore struct {
+	locker sync.Mutex
+	events []AnalyticsEvent
+}
+
+func NewMemoryAnalyticsStore() *MemoryAnalyticsStore {
+	return &MemoryAnalyticsStore{}
+}
+
+func (s *MemoryAnalyticsStore) SaveAnalyticsEvent(event AnalyticsEvent) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *MemoryAnalyticsStore) ListAnalyticsEvents(from, to time.Time) ([]AnalyticsEvent, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	events := []AnalyticsEvent{}
+	for _, event := range s.events {
+		if (from.IsZero() || !event.CreatedAt.Before(from)) &&
+			(to.IsZero() || event.CreatedAt.Before(to)) {
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.Before(events[j].CreatedAt)
+	})
+	return events, nil
+}
+
+// SQLAnalyticsStore - event log in MySQL, PostgreSQL or SQLite table.
+// Table schemas can be found in features/analytics_events*.sql
+type SQLAnalyticsStore struct {
+	db      *sql.DB
+	table   string // quoted
+	dialect sqlDialect
+}
+
+func NewMySQLAnalyticsStore(db *sql.DB, tableName string) *SQLAnalyticsStore {
+	return newSQLAnalyticsStore(db, tableName, sqlDialectMySQL)
+}
+
+func NewPostgresAnalyticsStore(db *sql.DB, tableName string) *SQLAnalyticsStore {
+	return newSQLAnalyticsStore(db, tableName, sqlDialectPostgres)
+}
+
+func NewSQLiteAnalyticsStore(db *sql.DB, tableName string) *SQLAnalyticsStore {
+	return newSQLAnalyticsStore(db, tableName, sqlDialectSQLite)
+}
+
+func newSQLAnalyticsStore(db *sql.DB, tableName string, dialect sqlDialect) *SQLAnalyticsStore {
+	return &SQLAnalyticsStore{
+		db:      db,
+		table:   dialect.quoteIdent(tableName),
+		dialect: dialect,
+	}
+}
+
+const sqlAnalyticsEventColumns = "tid,kind,event_id,button,conversion,utm_source,utm_campaign,created_at"
+
+func (s *SQLAnalyticsStore) SaveAnalyticsEvent(event AnalyticsEvent) error {
+	sqlQuery := s.dialect.rebind(
+		"INSERT INTO " + s.table + " (" + sqlAnalyticsEventColumns + ") VALUES (?,?,?,?,?,?,?,?)",
+	)
+	_, err := s.db.Exec(
+		sqlQuery,
+		event.TelegramUserID,
+		string(event.Kind),
+		event.EventID,
+		event.Button,
+		event.Conversion,
+		event.UTMSource,
+		event.UTMCampaign,
+		toUnixTimestamp(event.CreatedAt),
+	)
+	if err != nil {
+		return errors.New("failed to save analytics event: " + err.Error())
+	}
+	return nil
+}
+
+func (s *SQLAnalyticsStore) ListAnalyticsEvents(from, to time.Time) ([]AnalyticsEvent, error) {
+	sqlQuery := "SELECT " + sqlAnalyticsEventColumns + " FROM " + s.table + " WHERE 1=1"
+	var args []interface{}
+	if !from.IsZero() {
+		sqlQuery += " AND created_at>=?"
+		args = append(args, from.Unix())
+	}
+	if !to.IsZero() {
+		sqlQuery += " AND created_at<?"
+		args = append(args, to.Unix())
+	}
+	sqlQuery += " ORDER BY created_at, id"
+
+	rows, err := s.db.Query(s.dialect.rebind(sqlQuery), args...)
+	if err != nil {
+		return nil, errors.New("failed to select analytics events: " + err.Error())
+	}
+	defer rows.Close()
+
+	events := []AnalyticsEvent{}
+	for rows.Next() {
+		var event AnalyticsEvent
+		var kind string
+		var createdAt int64
+		err := rows.Scan(
+			&event.TelegramUserID,
+			&kind,
+			&event.EventID,
+			&event.Button,
+			&event.Conversion,
+			&event.UTMSource,
+			&event.UTMCampaign,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, errors.New("failed to scan analytics event: " + err.Error())
+		}
+
+		event.Kind = AnalyticsEventKind(kind)
+		event.CreatedAt = fromUnixTimestamp(createdAt)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("failed to select analytics events: " + err.Error())
+	}
+	return events, nil
+}
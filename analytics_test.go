@@ -0,0 +1,114 @@
+package tgfun
+
+import (
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+)
+
+func TestBuildFunnelReport(t *testing.T) {
+	// given
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+	delivery := func(userID int64, minutes int, source string) AnalyticsEvent {
+		return AnalyticsEvent{
+			TelegramUserID: userID,
+			Kind:           AnalyticsEventDelivery,
+			EventID:        startMessageCode,
+			UTMSource:      source,
+			CreatedAt:      at(minutes),
+		}
+	}
+	conversion := func(userID int64, minutes int, tag string) AnalyticsEvent {
+		return AnalyticsEvent{
+			TelegramUserID: userID,
+			Kind:           AnalyticsEventConversion,
+			Conversion:     tag,
+			CreatedAt:      at(minutes),
+		}
+	}
+	events := []AnalyticsEvent{
+		delivery(1, 0, "ads"),
+		delivery(2, 0, "ads"),
+		delivery(3, 0, ""),
+		conversion(1, 10, "lead"),
+		conversion(2, 20, "lead"),
+		conversion(3, 30, "sale"), // sale without lead is not counted
+		conversion(1, 40, "sale"),
+		conversion(4, 50, "lead"), // not started
+	}
+
+	// when
+	report := buildFunnelReport(events, []string{"lead", "sale"})
+
+	// then
+	assert.Equal(t, 3, report.Total.Users)
+	require.Len(t, report.Total.Steps, 2)
+	assert.Equal(t, 2, report.Total.Steps[0].Users)
+	assert.Equal(t, 15*time.Minute, report.Total.Steps[0].MedianTimeToConvert)
+	assert.Equal(t, 1, report.Total.Steps[1].Users)
+	assert.InDelta(t, 1.0/3, report.Total.Steps[1].Rate, 0.001)
+	assert.InDelta(t, 0.5, report.Total.Steps[1].StepRate, 0.001)
+	assert.Equal(t, 40*time.Minute, report.Total.Steps[1].MedianTimeToConvert)
+
+	require.Len(t, report.Cohorts, 2)
+	assert.Equal(t, "ads", report.Cohorts[0].UTMSource)
+	assert.Equal(t, 2, report.Cohorts[0].Users)
+	assert.Equal(t, 2, report.Cohorts[0].Steps[0].Users)
+	assert.Equal(t, "", report.Cohorts[1].UTMSource)
+	assert.Equal(t, 0, report.Cohorts[1].Steps[0].Users)
+}
+
+func TestBuildFunnelReportDefaultSteps(t *testing.T) {
+	// given
+	now := time.Now()
+	events := []AnalyticsEvent{
+		{TelegramUserID: 1, Kind: AnalyticsEventDelivery, EventID: startMessageCode, CreatedAt: now},
+		{TelegramUserID: 2, Kind: AnalyticsEventDelivery, EventID: startMessageCode, CreatedAt: now},
+		{TelegramUserID: 1, Kind: AnalyticsEventConversion, Conversion: "sale", CreatedAt: now},
+		{TelegramUserID: 1, Kind: AnalyticsEventConversion, Conversion: "lead", CreatedAt: now},
+		{TelegramUserID: 2, Kind: AnalyticsEventConversion, Conversion: "lead", CreatedAt: now},
+	}
+
+	// when
+	report := buildFunnelReport(events, nil)
+
+	// then
+	require.Len(t, report.Total.Steps, 2)
+	assert.Equal(t, "lead", report.Total.Steps[0].Conversion)
+	assert.Equal(t, "sale", report.Total.Steps[1].Conversion)
+}
+
+func TestFunnelReportBacklinkVisit(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{
+		startMessageCode: {Message: EventMessage{Text: "hello"}},
+		"offer":          {Message: EventMessage{Text: "offer"}},
+	})
+	require.NoError(t, f.EnableAnalyticsFeature(AnalyticsFeature{Store: NewMemoryAnalyticsStore()}))
+
+	deliver := func(eventID, payloadRaw string) {
+		payload, err := FilterUserPayload(payloadRaw)
+		require.NoError(t, err)
+		q, err := f.GetEventQueryHandler(eventID)
+		require.NoError(t, err)
+		q.payload = payload
+		q.recordDelivery(1, eventID, q.EventData)
+	}
+
+	// when
+	deliver(startMessageCode, "")
+	deliver("offer", "offer_back")
+	report, err := f.GetFunnelReport(FunnelReportOptions{})
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, report.Cohorts, 1)
+	assert.Equal(t, "", report.Cohorts[0].UTMSource)
+	assert.Equal(t, "", report.Cohorts[0].UTMCampaign)
+	assert.Equal(t, 1, report.Cohorts[0].Users)
+}
@@ -0,0 +1,492 @@
+package tgfun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	defaultBroadcastRate        = 25 // messages per second, telegram allows ~30
+	defaultBroadcastReportEvery = time.Minute
+	broadcastChatInterval       = time.Second // telegram allows ~1 message per second in one chat
+	broadcastMaxRetries         = 3
+)
+
+type BroadcastState string
+
+const (
+	BroadcastStateRunning  BroadcastState = "running"
+	BroadcastStatePaused   BroadcastState = "paused"
+	BroadcastStateFinished BroadcastState = "finished"
+	BroadcastStateCanceled BroadcastState = "canceled"
+)
+
+// BroadcastOptions - broadcast settings
+type BroadcastOptions struct {
+	// required: script event ID or event itself
+	EventID string
+	Event   *FunnelEvent
+
+	// optional
+	Filter      func(user User) bool // select users. all users when not set
+	ReportEvery time.Duration        // progress report to admin chat. default: 1 min
+	// broadcast rate limit. default: 25.
+	// Broadcast and drip messages share the funnel limit of 25 per second
+	MessagesPerSecond int
+}
+
+// BroadcastProgress - broadcast counters
+type BroadcastProgress struct {
+	State      BroadcastState
+	Total      int
+	Sent       int
+	Failed     int
+	Blocked    int // users who blocked the bot
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+func (p BroadcastProgress) String() string {
+	return fmt.Sprintf(
+		"broadcast %s: %v/%v processed, %v sent, %v failed, %v blocked",
+		p.State, p.Sent+p.Failed+p.Blocked, p.Total, p.Sent, p.Failed, p.Blocked,
+	)
+}
+
+// Broadcast - running broadcast of funnel event to stored users
+type Broadcast struct {
+	funnel  *Funnel
+	eventID string
+	event   FunnelEvent
+	users   []User
+	limiter *sendLimiter
+	opts    BroadcastOptions
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	locker   sync.Mutex
+	progress BroadcastProgress
+	resume   chan struct{} // not nil when paused
+}
+
+// StartBroadcast - send event to all or filtered users in background.
+// Only one broadcast can run at a time
+func (f *Funnel) StartBroadcast(opts BroadcastOptions) (*Broadcast, error) {
+	if f.features.Users == nil {
+		return nil, errors.New("users feature is not enabled")
+	}
+	if f.bot == nil {
+		return nil, errors.New("funnel is not running")
+	}
+
+	eventID, event, err := f.getBroadcastEvent(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := f.getBroadcastUsers(opts.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("get users: %w", err)
+	}
+
+	if opts.MessagesPerSecond <= 0 {
+		opts.MessagesPerSecond = defaultBroadcastRate
+	}
+	if opts.ReportEvery <= 0 {
+		opts.ReportEvery = defaultBroadcastReportEvery
+	}
+
+	f.broadcastLocker.Lock()
+	defer f.broadcastLocker.Unlock()
+
+	if f.broadcast != nil && !f.broadcast.isDone() {
+		return nil, errors.New("another broadcast is running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Broadcast{
+		funnel:  f,
+		eventID: eventID,
+		event:   event,
+		users:   users,
+		limiter: newSendLimiter(time.Second/time.Duration(opts.MessagesPerSecond), 0),
+		opts:    opts,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		progress: BroadcastProgress{
+			State:     BroadcastStateRunning,
+			Total:     len(users),
+			StartedAt: time.Now(),
+		},
+	}
+	f.broadcast = b
+
+	go b.run(ctx)
+	return b, nil
+}
+
+// GetBroadcast returns the last started broadcast or nil
+func (f *Funnel) GetBroadcast() *Broadcast {
+	f.broadcastLocker.Lock()
+	defer f.broadcastLocker.Unlock()
+
+	return f.broadcast
+}
+
+func (f *Funnel) getBroadcastEvent(opts BroadcastOptions) (string, FunnelEvent, error) {
+	if opts.Event != nil {
+		return opts.EventID, *opts.Event, nil
+	}
+
+	if opts.EventID == "" {
+		return "", FunnelEvent{}, errors.New("broadcast event is not set")
+	}
+
+	event, isExists := f.getScript()[opts.EventID]
+	if !isExists {
+		return "", FunnelEvent{}, fmt.Errorf("event %q not exists in funnel", opts.EventID)
+	}
+	return opts.EventID, event, nil
+}
+
+func (f *Funnel) getBroadcastUsers(filter func(user User) bool) ([]User, error) {
+	users, err := f.features.Users.Store.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]User, 0, len(users))
+	for _, user := range users {
+		if user.IsBlocked {
+			continue
+		}
+		if filter != nil && !filter(user) {
+			continue
+		}
+		result = append(result, user)
+	}
+	return result, nil
+}
+
+func (f *Funnel) cancelBroadcast(ctx context.Context) error {
+	b := f.GetBroadcast()
+	if b == nil {
+		return nil
+	}
+
+	b.Cancel()
+	return b.Wait(ctx)
+}
+
+// Progress returns broadcast counters snapshot
+func (b *Broadcast) Progress() BroadcastProgress {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	return b.progress
+}
+
+// Pause sending. Already sent messages are not affected
+func (b *Broadcast) Pause() {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	if b.progress.State != BroadcastStateRunning {
+		return
+	}
+	b.progress.State = BroadcastStatePaused
+	b.resume = make(chan struct{})
+}
+
+// Resume paused broadcast
+func (b *Broadcast) Resume() {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	if b.progress.State != BroadcastStatePaused {
+		return
+	}
+	b.progress.State = BroadcastStateRunning
+	close(b.resume)
+	b.resume = nil
+}
+
+// Cancel broadcast. Use Wait to make sure sending is stopped
+func (b *Broadcast) Cancel() {
+	b.cancel()
+}
+
+// Wait for broadcast to finish
+func (b *Broadcast) Wait(ctx context.Context) error {
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for broadcast: %w", ctx.Err())
+	}
+}
+
+func (b *Broadcast) isDone() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *Broadcast) run(ctx context.Context) {
+	defer close(b.done)
+	defer b.cancel()
+
+	lastReport := time.Now()
+	for _, user := range b.users {
+		if err := b.waitIfPaused(ctx); err != nil {
+			break
+		}
+		if err := b.funnel.waitBulkSend(ctx, b.limiter, user.TelegramID); err != nil {
+			break
+		}
+
+		b.handleSendResult(user, b.sendWithRetry(ctx, user.TelegramID))
+
+		if time.Since(lastReport) >= b.opts.ReportEvery {
+			b.report()
+			lastReport = time.Now()
+		}
+	}
+
+	b.locker.Lock()
+	b.progress.State = BroadcastStateFinished
+	if ctx.Err() != nil {
+		b.progress.State = BroadcastStateCanceled
+	}
+	b.progress.FinishedAt = time.Now()
+	b.locker.Unlock()
+
+	b.report()
+}
+
+func (b *Broadcast) waitIfPaused(ctx context.Context) error {
+	b.locker.Lock()
+	resume := b.resume
+	b.locker.Unlock()
+
+	if resume == nil {
+		return ctx.Err()
+	}
+
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Broadcast) sendWithRetry(ctx context.Context, telegramUserID int64) error {
+	var err error
+	for attempt := 0; attempt < broadcastMaxRetries; attempt++ {
+		// handler is not reusable: the menu is filled on every send
+		q := b.funnel.newQueryHandler(b.eventID, b.event)
+
+		err = q.CustomHandle(telegramUserID)
+		if err == nil {
+			return nil
+		}
+
+		var floodErr tb.FloodError
+		if !errors.As(err, &floodErr) {
+			return err
+		}
+
+		// too many requests, wait as telegram asks
+		select {
+		case <-time.After(time.Duration(floodErr.RetryAfter) * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func (b *Broadcast) handleSendResult(user User, err error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	switch {
+	case err == nil:
+		b.progress.Sent++
+	case isUserBlockedError(err):
+		b.progress.Blocked++
+		if err := b.funnel.features.Users.Store.MarkUserBlocked(user.TelegramID); err != nil {
+			b.reportError(user.TelegramID, fmt.Errorf("mark user blocked: %w", err))
+		}
+	default:
+		b.progress.Failed++
+		b.reportError(user.TelegramID, err)
+	}
+}
+
+func (b *Broadcast) reportError(telegramUserID int64, err error) {
+	b.funnel.errReporter.report(ErrorEvent{
+		UserID:  telegramUserID,
+		EventID: b.eventID,
+		Stage:   ErrorStageBroadcast,
+		Err:     err,
+	})
+}
+
+// chat not found is not permanent, so it's counted as failed send
+func isUserBlockedError(err error) bool {
+	return errors.Is(err, tb.ErrBlockedByUser) ||
+		errors.Is(err, tb.ErrUserIsDeactivated)
+}
+
+func (b *Broadcast) report() {
+	adminChatID := b.funnel.features.Users.AdminChatID
+	if adminChatID == 0 {
+		return
+	}
+
+	_, err := b.funnel.bot.Send(tb.ChatID(adminChatID), b.Progress().String())
+	if err != nil {
+		b.reportError(0, fmt.Errorf("send report: %w", err))
+	}
+}
+
+func (f *Funnel) isAdminMessage(ctx tb.Context) bool {
+	return f.features.Users != nil &&
+		f.features.Users.AdminChatID != 0 &&
+		ctx.Chat() != nil &&
+		ctx.Chat().ID == f.features.Users.AdminChatID &&
+		strings.HasPrefix(ctx.Text(), adminCommandPrefix)
+}
+
+// admin commands:
+// !all eventID - send script event to all users
+// !all text - send text to all users
+// !pause, !resume, !cancel, !status - control running broadcast
+func (f *Funnel) handleAdminMessage(ctx tb.Context) error {
+	command, arg, _ := strings.Cut(ctx.Text(), " ")
+	arg = strings.TrimSpace(arg)
+
+	if command == adminPostToAllPrefix {
+		return f.handleAdminBroadcast(ctx, arg)
+	}
+
+	b := f.GetBroadcast()
+	if b == nil {
+		return ctx.Send("no broadcast started")
+	}
+
+	switch command {
+	default:
+		return ctx.Send("Не могу разобрать сообщение")
+	case adminPausePrefix:
+		b.Pause()
+	case adminResumePrefix:
+		b.Resume()
+	case adminCancelPrefix:
+		b.Cancel()
+	case adminStatusPrefix:
+	}
+	return ctx.Send(b.Progress().String())
+}
+
+func (f *Funnel) handleAdminBroadcast(ctx tb.Context, arg string) error {
+	if arg == "" {
+		return ctx.Send("usage: " + adminPostToAllPrefix + " <event ID or text>")
+	}
+
+	opts := BroadcastOptions{EventID: arg}
+	if _, isExists := f.getScript()[arg]; !isExists {
+		opts = BroadcastOptions{Event: &FunnelEvent{
+			Message: EventMessage{Text: arg},
+		}}
+	}
+
+	b, err := f.StartBroadcast(opts)
+	if err != nil {
+		return ctx.Send("failed to start broadcast: " + err.Error())
+	}
+	return ctx.Send(b.Progress().String())
+}
+
+// wait for the rate of broadcast or drip and for the shared funnel limits
+func (f *Funnel) waitBulkSend(ctx context.Context, rate *sendLimiter, chatID int64) error {
+	if err := rate.Wait(ctx, chatID); err != nil {
+		return err
+	}
+	return f.sendLimiter.Wait(ctx, chatID)
+}
+
+// global and per chat rate limiter. Chats are not limited when chatInterval is 0
+type sendLimiter struct {
+	interval     time.Duration
+	chatInterval time.Duration
+
+	locker   sync.Mutex
+	next     time.Time
+	chatNext map[int64]time.Time
+	pruneAt  time.Time // next removal of passed chatNext entries
+}
+
+func newSendLimiter(interval, chatInterval time.Duration) *sendLimiter {
+	return &sendLimiter{
+		interval:     interval,
+		chatInterval: chatInterval,
+		chatNext:     map[int64]time.Time{},
+	}
+}
+
+// Wait until message can be sent to chat
+func (l *sendLimiter) Wait(ctx context.Context, chatID int64) error {
+	l.locker.Lock()
+	now := time.Now()
+	l.prune(now)
+	sendAt := now
+	if l.next.After(sendAt) {
+		sendAt = l.next
+	}
+	if chatNext := l.chatNext[chatID]; chatNext.After(sendAt) {
+		sendAt = chatNext
+	}
+	l.next = sendAt.Add(l.interval)
+	if l.chatInterval > 0 {
+		l.chatNext[chatID] = sendAt.Add(l.chatInterval)
+	}
+	l.locker.Unlock()
+
+	timer := time.NewTimer(time.Until(sendAt))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// remove chats, that can be sent to already.
+// Scans the map at most once per chat interval
+func (l *sendLimiter) prune(now time.Time) {
+	if now.Before(l.pruneAt) {
+		return
+	}
+	l.pruneAt = now.Add(l.chatInterval)
+
+	for chatID, chatNext := range l.chatNext {
+		if !chatNext.After(now) {
+			delete(l.chatNext, chatID)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package tgfun
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestSendLimiterChatInterval(t *testing.T) {
+	// given
+	limiter := newSendLimiter(time.Millisecond, 50*time.Millisecond)
+	ctx := context.Background()
+
+	// when
+	startedAt := time.Now()
+	require.NoError(t, limiter.Wait(ctx, 1))
+	require.NoError(t, limiter.Wait(ctx, 2))
+	otherChatDelay := time.Since(startedAt)
+	require.NoError(t, limiter.Wait(ctx, 1))
+	sameChatDelay := time.Since(startedAt)
+
+	// then
+	assert.True(t, otherChatDelay < 50*time.Millisecond)
+	assert.True(t, sameChatDelay >= 50*time.Millisecond)
+}
+
+func TestSendLimiterPrunesPassedChats(t *testing.T) {
+	// given
+	limiter := newSendLimiter(0, 10*time.Millisecond)
+	ctx := context.Background()
+	require.NoError(t, limiter.Wait(ctx, 1))
+	require.NoError(t, limiter.Wait(ctx, 2))
+
+	// when
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, limiter.Wait(ctx, 3))
+
+	// then
+	assert.Len(t, limiter.chatNext, 1)
+	assert.Contains(t, limiter.chatNext, int64(3))
+}
+
+func TestBulkSendSharesFunnelLimiter(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{})
+	f.sendLimiter = newSendLimiter(time.Millisecond, 50*time.Millisecond)
+	broadcastRate := newSendLimiter(0, 0)
+	dripRate := newSendLimiter(0, 0)
+	ctx := context.Background()
+
+	// when
+	startedAt := time.Now()
+	require.NoError(t, f.waitBulkSend(ctx, broadcastRate, 1))
+	require.NoError(t, f.waitBulkSend(ctx, dripRate, 1))
+	delay := time.Since(startedAt)
+
+	// then
+	assert.True(t, delay >= 50*time.Millisecond)
+	assert.Empty(t, broadcastRate.chatNext)
+}
+
+func TestSendLimiterCanceled(t *testing.T) {
+	// given
+	limiter := newSendLimiter(time.Hour, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, limiter.Wait(ctx, 1))
+
+	// when
+	cancel()
+	err := limiter.Wait(ctx, 2)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestIsUserBlockedError(t *testing.T) {
+	// when
+	isBlocked := isUserBlockedError(tb.ErrBlockedByUser)
+	isDeactivated := isUserBlockedError(tb.ErrUserIsDeactivated)
+	isChatNotFound := isUserBlockedError(tb.ErrChatNotFound)
+
+	// then
+	assert.True(t, isBlocked)
+	assert.True(t, isDeactivated)
+	assert.False(t, isChatNotFound) // failed send, user is not marked
+}
@@ -0,0 +1,72 @@
+package tgfun
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	buttonParamContextKey = "tgfun.buttonParam"
+	// long params are replaced with prefix and hash
+	buttonParamHashPrefix = "~"
+	buttonParamHashLen    = 12
+)
+
+// ButtonParamFromContext returns param of clicked button in OnEvent callback.
+// Returns empty string when event is not opened by button with param
+func ButtonParamFromContext(ctx tb.Context) string {
+	param, _ := ctx.Get(buttonParamContextKey).(string)
+	return param
+}
+
+// button params lookup table for params, that don't fit into callback data
+type buttonParams struct {
+	data sync.Map // hash -> param
+}
+
+// register params of all script buttons,
+// so buttons sent before restart keep working
+func (p *buttonParams) registerScript(script FunnelScript) {
+	for _, event := range script {
+		for _, btn := range event.Message.Buttons {
+			if btn.URL == "" && btn.Param != "" {
+				p.encode(btn.NextMessageID, btn.Param)
+			}
+		}
+	}
+}
+
+// returns callback data to pass after button unique
+func (p *buttonParams) encode(nextEventID, param string) string {
+	if param == "" {
+		return ""
+	}
+
+	// telebot encodes data button as "\f" + unique + "|" + data
+	if len(nextEventID)+len(param)+2 <= maxCallbackDataLen &&
+		!strings.HasPrefix(param, buttonParamHashPrefix) {
+		return param
+	}
+
+	hash := sha256.Sum256([]byte(param))
+	key := buttonParamHashPrefix + base64.RawURLEncoding.EncodeToString(hash[:])[:buttonParamHashLen]
+	p.data.Store(key, param)
+	return key
+}
+
+// returns false when hashed param is not found, e.g. after restart
+func (p *buttonParams) decode(data string) (string, bool) {
+	if !strings.HasPrefix(data, buttonParamHashPrefix) {
+		return data, true
+	}
+
+	param, isFound := p.data.Load(data)
+	if !isFound {
+		return "", false
+	}
+	return param.(string), true
+}
@@ -0,0 +1,50 @@
+package tgfun
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestButtonParamsShortParam(t *testing.T) {
+	// given
+	params := buttonParams{}
+
+	// when
+	data := params.encode("product", "42")
+
+	// then
+	assert.Equal(t, "42", data)
+	param, isFound := params.decode(data)
+	assert.True(t, isFound)
+	assert.Equal(t, "42", param)
+}
+
+func TestButtonParamsLongParam(t *testing.T) {
+	// given
+	params := buttonParams{}
+	param := strings.Repeat("long-product-id-", 5)
+
+	// when
+	data := params.encode("product", param)
+
+	// then
+	assert.True(t, strings.HasPrefix(data, buttonParamHashPrefix))
+	assert.True(t, len("product")+len(data)+2 <= maxCallbackDataLen)
+	decoded, isFound := params.decode(data)
+	assert.True(t, isFound)
+	assert.Equal(t, param, decoded)
+}
+
+func TestButtonParamsUnknownHash(t *testing.T) {
+	// given
+	params := buttonParams{}
+
+	// when
+	param, isFound := params.decode(buttonParamHashPrefix + "unknown")
+
+	// then
+	assert.False(t, isFound)
+	assert.Empty(t, param)
+}
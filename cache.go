@@ -2,7 +2,7 @@ package tgfun
 
 import (
 	"fmt"
-	"log"
+	"os"
 	"sync"
 	"time"
 
@@ -18,68 +18,74 @@ type Resource struct {
 	ExpireAt     time.Time `json:"expireAt"`
 }
 
+// ResourcesCache - file IDs of uploaded local files, so files are uploaded once
 type ResourcesCache struct {
 	enabled bool
 	root    string
-	path    string
-	data    sync.Map
+	store   ResourceStore
 
-	writeLocker sync.Mutex
-	hashLocker  sync.Mutex
+	errReporter *errorReporter // default logger when nil
+	metrics     *funnelMetrics // nil when metrics are disabled
+
+	hashLocker sync.Mutex
+	hashes     map[string]fileHash // file path -> hash
+}
+
+// hash of file content, valid while file size and modification time are the same
+type fileHash struct {
+	hash    string
+	size    int64
+	modTime time.Time
 }
 
+// NewResourceCache - cache in JSON lines file at cachePath
 func NewResourceCache(cachePath string, pathRoot string) *ResourcesCache {
-	if cachePath == "" {
-		log.Println("cache path is not set. skip")
-		return &ResourcesCache{enabled: false}
-	}
+	return newResourceCache(cachePath, pathRoot, nil)
+}
 
-	r := &ResourcesCache{
-		enabled: true,
-		root:    pathRoot,
-		path:    cachePath,
+func newResourceCache(
+	cachePath string,
+	pathRoot string,
+	errReporter *errorReporter,
+) *ResourcesCache {
+	if cachePath == "" {
+		errReporter.getLogger().Info("cache path is not set. skip")
+		return &ResourcesCache{enabled: false, root: pathRoot}
 	}
 
-	if swissknife.IsFileExists(cachePath) {
-		if err := r.load(); err != nil {
-			r.enabled = false
-			log.Printf(
-				"load funnel cache from %q: %s\n",
-				cachePath, err.Error(),
-			)
-			return r
-		}
+	store, err := NewFileResourceStore(cachePath)
+	if err != nil {
+		errReporter.report(ErrorEvent{
+			Stage: ErrorStageCache,
+			Err:   fmt.Errorf("load funnel cache: %w", err),
+		})
+		return &ResourcesCache{enabled: false, root: pathRoot}
 	}
-	return r
+	return NewResourceCacheWithStore(store, pathRoot)
 }
 
-func (r *ResourcesCache) load() error {
-	rawData := map[string]Resource{}
-	if err := swissknife.ParseStructFromJSONFile(r.path, &rawData); err != nil {
-		return fmt.Errorf("parse: %w", err)
-	}
+func (r *ResourcesCache) reportError(err error) {
+	r.errReporter.report(ErrorEvent{Stage: ErrorStageCache, Err: err})
+}
 
-	for k, v := range rawData {
-		r.data.Store(k, v)
+// NewResourceCacheWithStore - cache in custom storage,
+// e.g. SQL table shared by several bot instances
+func NewResourceCacheWithStore(store ResourceStore, pathRoot string) *ResourcesCache {
+	return &ResourcesCache{
+		enabled: true,
+		root:    pathRoot,
+		store:   store,
 	}
-	return nil
 }
 
-func (r *ResourcesCache) save() error {
-	r.writeLocker.Lock()
-	defer r.writeLocker.Unlock()
-
-	rawData := map[string]Resource{}
-
-	r.data.Range(func(key, value any) bool {
-		rawData[key.(string)] = value.(Resource)
-		return true
-	})
-
-	if err := swissknife.SaveStructToJSONFileIndent(rawData, r.path); err != nil {
-		return fmt.Errorf("save: %w", err)
+// returns nil when resource not found or store is failed
+func (r *ResourcesCache) getResource(localFilePath string) *Resource {
+	res, err := r.store.GetResource(localFilePath)
+	if err != nil {
+		r.reportError(fmt.Errorf("get %q: %w", localFilePath, err))
+		return nil
 	}
-	return nil
+	return res
 }
 
 func (r *ResourcesCache) Get(localFilePath string) telebot.File {
@@ -88,15 +94,10 @@ func (r *ResourcesCache) Get(localFilePath string) telebot.File {
 		return telebot.FromDisk(filePath)
 	}
 
-	res, isExists := r.data.Load(localFilePath)
-	if !isExists {
-		return telebot.FromDisk(filePath)
-	}
-
-	resData := res.(Resource)
-
-	if !resData.ExpireAt.After(time.Now()) {
-		return telebot.FromDisk(filePath) // expired
+	resData, isActual := r.getActualResource(localFilePath)
+	r.metrics.onCacheLookup(isActual)
+	if !isActual {
+		return telebot.FromDisk(filePath) // not uploaded, expired or changed
 	}
 
 	return telebot.File{
@@ -106,6 +107,21 @@ func (r *ResourcesCache) Get(localFilePath string) telebot.File {
 	}
 }
 
+// returns resource and true, when uploaded file is not expired
+// and local file is not changed since upload
+func (r *ResourcesCache) getActualResource(localFilePath string) (*Resource, bool) {
+	resData := r.getResource(localFilePath)
+	if resData == nil || !resData.ExpireAt.After(time.Now()) {
+		return nil, false
+	}
+
+	fileHash := r.getActualHash(getFilePath(localFilePath, r.root))
+	if resData.Hash != fileHash && fileHash != "" {
+		return nil, false
+	}
+	return resData, true
+}
+
 func (r *ResourcesCache) Actualize(
 	localFilePath string,
 	fileData telebot.File,
@@ -115,10 +131,7 @@ func (r *ResourcesCache) Actualize(
 	}
 
 	if err := r.Update(localFilePath, fileData); err != nil {
-		log.Printf(
-			"failed to update res %q: %s\n",
-			localFilePath, err.Error(),
-		)
+		r.reportError(fmt.Errorf("update %q: %w", localFilePath, err))
 	}
 }
 
@@ -130,37 +143,41 @@ func (r *ResourcesCache) IsNeedUpdate(
 		return false
 	}
 
-	// load
-	var resData Resource
-	resRaw, _ := r.data.LoadOrStore(localFilePath, Resource{})
-	resData = resRaw.(Resource)
+	_, isActual := r.getActualResource(localFilePath)
+	return !isActual
+}
 
-	if !resData.ExpireAt.After(time.Now()) {
-		return true // entry expired
+// file is hashed again only when it's changed
+func (r *ResourcesCache) getActualHash(filePath string) string {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return ""
 	}
 
-	// check
 	r.hashLocker.Lock()
 	defer r.hashLocker.Unlock()
 
-	filePath := getFilePath(localFilePath, r.root)
-	fileHash := r.getActualHash(filePath)
-	return resData.Hash != fileHash && fileHash != ""
-}
-
-func (r *ResourcesCache) getActualHash(filePath string) string {
-	if !swissknife.IsFileExists(filePath) {
-		return ""
+	if h, isExists := r.hashes[filePath]; isExists &&
+		h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+		return h.hash
 	}
+
 	fileBytes, err := swissknife.ReadFileToBytes(filePath)
 	if err != nil {
-		log.Printf("res cache: read %q: %s\n", filePath, err.Error())
+		r.reportError(fmt.Errorf("read %q: %w", filePath, err))
 		return ""
 	}
 
-	// TODO: можно сделать и кеш MD5-хешей,
-	// чтобы кеширование происходило только при запуске
-	return swissknife.MD5(fileBytes)
+	if r.hashes == nil {
+		r.hashes = map[string]fileHash{}
+	}
+	hash := swissknife.MD5(fileBytes)
+	r.hashes[filePath] = fileHash{
+		hash:    hash,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}
+	return hash
 }
 
 func (r *ResourcesCache) Update(
@@ -173,9 +190,8 @@ func (r *ResourcesCache) Update(
 
 	// load
 	var resData Resource
-	resRaw, isExists := r.data.Load(localFilePath)
-	if isExists {
-		resData = resRaw.(Resource)
+	if res := r.getResource(localFilePath); res != nil {
+		resData = *res
 	}
 
 	// update
@@ -186,8 +202,7 @@ func (r *ResourcesCache) Update(
 	resData.ExpireAt = time.Now().Add(resourceCacheExpiration)
 
 	// save
-	r.data.Store(localFilePath, resData)
-	if err := r.save(); err != nil {
+	if err := r.store.SaveResource(localFilePath, resData); err != nil {
 		return fmt.Errorf("save: %w", err)
 	}
 	return nil
@@ -0,0 +1,208 @@
+package tgfun
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	swissknife "github.com/Sagleft/swiss-knife"
+	tb "gopkg.in/telebot.v3"
+)
+
+// telegram allows ~20 messages per minute in one group
+const warmUpUploadInterval = 3 * time.Second
+
+// CacheWarmUpData - upload script media to service chat at startup,
+// so the first users get files by cached IDs as well
+type CacheWarmUpData struct {
+	// service chat for uploads. warm-up is disabled when not set
+	ChatID int64 `json:"chatID"`
+	// optional. check media changes and upload changed files, example: "1m"
+	WatchInterval string `json:"watchInterval"`
+	// optional. uploaded messages are deleted from chat by default
+	KeepMessages bool `json:"keepMessages"`
+}
+
+func (d CacheWarmUpData) IsEnabled() bool {
+	return d.ChatID != 0
+}
+
+func (d CacheWarmUpData) getWatchInterval() (time.Duration, error) {
+	if d.WatchInterval == "" {
+		return 0, nil
+	}
+	return parseDelay(d.WatchInterval)
+}
+
+// local media file referenced by script
+type mediaRef struct {
+	path        string
+	messageType MessageType
+	fileName    string // document file name
+}
+
+// returns local media of script events, each file once
+func (s FunnelScript) getLocalMedia() []mediaRef {
+	var refs []mediaRef
+	isAdded := map[string]bool{}
+	add := func(path string, messageType MessageType, fileName string) {
+		if path == "" || isExternalFile(path) || isAdded[path] {
+			return
+		}
+		isAdded[path] = true
+		refs = append(refs, mediaRef{path: path, messageType: messageType, fileName: fileName})
+	}
+
+	for _, eventID := range s.eventIDs() {
+		msg := s[eventID].Message
+		if msg.Image != "parametric" {
+			add(msg.Image, MessageTypePhoto, "")
+		}
+		add(msg.File.Path, MessageTypeDocument, msg.File.Name)
+		add(msg.Video.Path, MessageTypeVideo, "")
+		add(msg.Audio.Path, MessageTypeAudio, "")
+		add(msg.Voice.Path, MessageTypeVoice, "")
+		add(msg.VideoNote.Path, MessageTypeVideoNote, "")
+		add(msg.Animation.Path, MessageTypeAnimation, "")
+		add(msg.Sticker.Path, MessageTypeSticker, "")
+		for _, item := range msg.Album {
+			add(item.getPath(), item.getType(), "")
+		}
+	}
+	return refs
+}
+
+func (ref mediaRef) toSendable(filePath string) tb.Sendable {
+	file := tb.FromDisk(filePath)
+	switch ref.messageType {
+	default:
+		return &tb.Photo{File: file}
+	case MessageTypeDocument:
+		fileName := ref.fileName
+		if fileName == "" {
+			fileName = filepath.Base(filePath)
+		}
+		return &tb.Document{File: file, FileName: fileName}
+	case MessageTypeVideo:
+		return &tb.Video{File: file, FileName: "video.mp4"}
+	case MessageTypeAudio:
+		return &tb.Audio{File: file}
+	case MessageTypeVoice:
+		return &tb.Voice{File: file}
+	case MessageTypeVideoNote:
+		return &tb.VideoNote{File: file}
+	case MessageTypeAnimation:
+		return &tb.Animation{File: file}
+	case MessageTypeSticker:
+		return &tb.Sticker{File: file}
+	}
+}
+
+func (f *Funnel) startCacheWarmUp() {
+	warmUp := f.Data.CacheWarmUp
+	if !warmUp.IsEnabled() || !f.resCache.enabled {
+		return
+	}
+	watchInterval, _ := warmUp.getWatchInterval() // checked by Validate
+
+	f.startBackground("cache warm-up", func(ctx context.Context) {
+		f.runCacheWarmUp(ctx, watchInterval)
+	})
+}
+
+// upload media, then check changes on every watch interval
+func (f *Funnel) runCacheWarmUp(ctx context.Context, watchInterval time.Duration) {
+	limiter := newSendLimiter(0, warmUpUploadInterval)
+	f.warmUpCache(ctx, limiter, f.getScript().getLocalMedia())
+	if watchInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// script can be reloaded with new media
+			f.warmUpCache(ctx, limiter, f.getScript().getLocalMedia())
+		}
+	}
+}
+
+// upload files, that are not cached, expired or changed
+func (f *Funnel) warmUpCache(ctx context.Context, limiter *sendLimiter, media []mediaRef) {
+	uploaded := 0
+	for _, ref := range media {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, isActual := f.resCache.getActualResource(ref.path); isActual {
+			continue
+		}
+
+		filePath := getFilePath(ref.path, f.resCache.root)
+		if !swissknife.IsFileExists(filePath) {
+			continue // reported by Validate
+		}
+
+		if err := limiter.Wait(ctx, f.Data.CacheWarmUp.ChatID); err != nil {
+			return
+		}
+		if err := f.uploadToCache(ref, filePath); err != nil {
+			f.errReporter.report(ErrorEvent{
+				Stage: ErrorStageCache,
+				Err:   fmt.Errorf("warm-up: upload %q: %w", ref.path, err),
+			})
+			continue
+		}
+		uploaded++
+	}
+
+	if uploaded > 0 {
+		f.errReporter.getLogger().Info("cache warm-up: files uploaded", "count", uploaded)
+	}
+}
+
+func (f *Funnel) uploadToCache(ref mediaRef, filePath string) error {
+	chatID := tb.ChatID(f.Data.CacheWarmUp.ChatID)
+	response, err := f.bot.Send(chatID, ref.toSendable(filePath), tb.Silent)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	file, isFound := findFileInMessage(response, fileState{Type: ref.messageType})
+	if !isFound {
+		return fmt.Errorf("%s not found in sent message", ref.messageType)
+	}
+	if err := f.resCache.Update(ref.path, file); err != nil {
+		return fmt.Errorf("update cache: %w", err)
+	}
+
+	if !f.Data.CacheWarmUp.KeepMessages {
+		if err := f.bot.Delete(response); err != nil {
+			f.errReporter.report(ErrorEvent{
+				Stage: ErrorStageCache,
+				Err:   fmt.Errorf("warm-up: delete message: %w", err),
+			})
+		}
+	}
+	return nil
+}
+
+func (f *Funnel) validateCacheWarmUp(r *ValidationResult) {
+	warmUp := f.Data.CacheWarmUp
+	if !warmUp.IsEnabled() {
+		return
+	}
+
+	if f.resStore == nil && f.Data.ResourcesCachePath == "" {
+		r.addWarning("", "cacheWarmUp", "resources cache is disabled, warm-up is skipped")
+	}
+	if _, err := warmUp.getWatchInterval(); err != nil {
+		r.addError("", "cacheWarmUp.watchInterval", err.Error())
+	}
+}
@@ -0,0 +1,35 @@
+package tgfun
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestGetLocalMedia(t *testing.T) {
+	// given
+	script := FunnelScript{
+		"/start": {Message: EventMessage{
+			Image: "logo.png",
+			File:  FileData{Path: "guide.pdf", Name: "Guide.pdf"},
+		}},
+		"gallery": {Message: EventMessage{
+			Album: []AlbumItem{
+				{Image: "logo.png"},
+				{Video: "intro.mp4"},
+				{Image: "https://example.com/banner.png"},
+			},
+		}},
+		"input": {Message: EventMessage{Image: "parametric"}},
+	}
+
+	// when
+	media := script.getLocalMedia()
+
+	// then
+	assert.Equal(t, []mediaRef{
+		{path: "logo.png", messageType: MessageTypePhoto},
+		{path: "guide.pdf", messageType: MessageTypeDocument, fileName: "Guide.pdf"},
+		{path: "intro.mp4", messageType: MessageTypeVideo},
+	}, media)
+}
@@ -7,9 +7,18 @@ import (
 )
 
 const (
-	startMessageCode     = "/start"
-	parseMode            = tb.ModeMarkdown
+	startMessageCode = "/start"
+	parseMode        = tb.ModeMarkdown
+)
+
+// admin chat commands
+const (
+	adminCommandPrefix   = "!"
 	adminPostToAllPrefix = "!all"
+	adminPausePrefix     = "!pause"
+	adminResumePrefix    = "!resume"
+	adminCancelPrefix    = "!cancel"
+	adminStatusPrefix    = "!status"
 )
 
 type ParseFormat string
@@ -22,11 +31,16 @@ const (
 type MessageType string
 
 const (
-	MessageTypeText     MessageType = "text"
-	MessageTypePhoto    MessageType = "photo"
-	MessageTypeDocument MessageType = "document"
-	MessageTypeVideo    MessageType = "video"
-	MessageTypeAudio    MessageType = "audio"
+	MessageTypeText      MessageType = "text"
+	MessageTypePhoto     MessageType = "photo"
+	MessageTypeDocument  MessageType = "document"
+	MessageTypeVideo     MessageType = "video"
+	MessageTypeAudio     MessageType = "audio"
+	MessageTypeAlbum     MessageType = "album"
+	MessageTypeVoice     MessageType = "voice"
+	MessageTypeVideoNote MessageType = "video_note"
+	MessageTypeAnimation MessageType = "animation"
+	MessageTypeSticker   MessageType = "sticker"
 )
 
 const durationDay = time.Hour * 24
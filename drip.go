@@ -0,0 +1,241 @@
+package tgfun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	defaultDripPollInterval = 30 * time.Second
+	defaultDripBatchSize    = 100
+	dripMaxAttempts         = 3
+	dripRetryDelay          = time.Minute
+)
+
+// FollowUp - event sent with delay after the parent event is delivered
+type FollowUp struct {
+	EventID        string   `json:"eventID"`
+	Delay          string   `json:"delay"`    // example: "30m", "24h", "3d", "1d12h"
+	CancelOnEvents []string `json:"cancelOn"` // optional. don't send when user reached any of them
+}
+
+func (fu FollowUp) getDelay() (time.Duration, error) {
+	return parseDelay(fu.Delay)
+}
+
+// time.ParseDuration with days support
+func parseDelay(delay string) (time.Duration, error) {
+	var days time.Duration
+	if daysRaw, rest, isFound := strings.Cut(delay, "d"); isFound {
+		n, err := strconv.Atoi(daysRaw)
+		if err != nil {
+			return 0, fmt.Errorf("invalid delay %q", delay)
+		}
+		days = time.Duration(n) * durationDay
+		if rest == "" {
+			return days, nil
+		}
+		delay = rest
+	}
+
+	d, err := time.ParseDuration(delay)
+	if err != nil {
+		return 0, fmt.Errorf("invalid delay %q", delay)
+	}
+	return days + d, nil
+}
+
+// DripJob - scheduled follow-up event delivery
+type DripJob struct {
+	ID             string // user ID, parent event ID and follow-up index
+	TelegramUserID int64
+	EventID        string
+	SendAt         time.Time
+	CancelOnEvents []string
+	Attempts       int
+}
+
+// DripFeature - feature to send FunnelEvent.FollowUps
+type DripFeature struct {
+	// required
+	Store DripJobStore
+
+	// optional
+	PollInterval      time.Duration // default: 30s
+	BatchSize         int           // due jobs per poll. default: 100
+	MessagesPerSecond int           // default: 25. shared funnel limit is 25 too
+}
+
+func (f *Funnel) EnableDripFeature(feature DripFeature) error {
+	if feature.Store == nil {
+		return errors.New("drip job store is not set")
+	}
+	if feature.PollInterval <= 0 {
+		feature.PollInterval = defaultDripPollInterval
+	}
+	if feature.BatchSize <= 0 {
+		feature.BatchSize = defaultDripBatchSize
+	}
+	if feature.MessagesPerSecond <= 0 {
+		feature.MessagesPerSecond = defaultBroadcastRate
+	}
+
+	f.features.Drip = &feature
+	return nil
+}
+
+func (f *funnelFeatures) IsDripFeatureActive() bool {
+	return f.Drip != nil
+}
+
+func getDripJobID(telegramUserID int64, parentEventID string, followUpIndex int) string {
+	return fmt.Sprintf("%v:%s:%v", telegramUserID, parentEventID, followUpIndex)
+}
+
+// cancel follow-ups waiting for this event and schedule event follow-ups
+func (df *DripFeature) onEventDelivered(
+	telegramUserID int64,
+	eventID string,
+	event FunnelEvent,
+) error {
+	jobs, err := df.Store.ListUserJobs(telegramUserID)
+	if err != nil {
+		return fmt.Errorf("list user jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		if !isStringInList(eventID, job.CancelOnEvents) {
+			continue
+		}
+		if err := df.Store.DeleteJob(job.ID); err != nil {
+			return fmt.Errorf("cancel job: %w", err)
+		}
+	}
+
+	now := time.Now()
+	for i, followUp := range event.FollowUps {
+		delay, err := followUp.getDelay()
+		if err != nil {
+			return fmt.Errorf("follow-up %v of %q: %w", i, eventID, err)
+		}
+
+		// the same job is rescheduled when user gets the event again
+		err = df.Store.SaveJob(DripJob{
+			ID:             getDripJobID(telegramUserID, eventID, i),
+			TelegramUserID: telegramUserID,
+			EventID:        followUp.EventID,
+			SendAt:         now.Add(delay),
+			CancelOnEvents: followUp.CancelOnEvents,
+		})
+		if err != nil {
+			return fmt.Errorf("schedule follow-up: %w", err)
+		}
+	}
+	return nil
+}
+
+func isStringInList(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Funnel) startDripScheduler() {
+	if !f.features.IsDripFeatureActive() {
+		return
+	}
+
+	f.startBackground("drip scheduler", f.runDripScheduler)
+}
+
+func (f *Funnel) runDripScheduler(ctx context.Context) {
+	df := f.features.Drip
+	limiter := newSendLimiter(time.Second/time.Duration(df.MessagesPerSecond), 0)
+
+	ticker := time.NewTicker(df.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.sendDueDripJobs(ctx, limiter); err != nil {
+			f.errReporter.report(ErrorEvent{Stage: ErrorStageDrip, Err: err})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *Funnel) sendDueDripJobs(ctx context.Context, limiter *sendLimiter) error {
+	df := f.features.Drip
+
+	jobs, err := df.Store.ListDueJobs(time.Now(), df.BatchSize)
+	if err != nil {
+		return fmt.Errorf("list due jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		if err := f.waitBulkSend(ctx, limiter, job.TelegramUserID); err != nil {
+			return nil // stopped
+		}
+
+		if err := f.sendDripJob(job); err != nil {
+			f.errReporter.report(ErrorEvent{
+				UserID:  job.TelegramUserID,
+				EventID: job.EventID,
+				Stage:   ErrorStageDrip,
+				Err:     fmt.Errorf("job %q: %w", job.ID, err),
+			})
+		}
+	}
+	return nil
+}
+
+func (f *Funnel) sendDripJob(job DripJob) error {
+	df := f.features.Drip
+
+	q, err := f.GetEventQueryHandler(job.EventID)
+	if err != nil {
+		// event was removed from script
+		return errors.Join(err, df.Store.DeleteJob(job.ID))
+	}
+
+	sendErr := q.CustomHandle(job.TelegramUserID)
+	if sendErr == nil {
+		return df.Store.DeleteJob(job.ID)
+	}
+
+	if isUserBlockedError(sendErr) {
+		if f.features.Users != nil {
+			if err := f.features.Users.Store.MarkUserBlocked(job.TelegramUserID); err != nil {
+				sendErr = errors.Join(sendErr, fmt.Errorf("mark user blocked: %w", err))
+			}
+		}
+		return errors.Join(sendErr, df.Store.DeleteJob(job.ID))
+	}
+
+	job.Attempts++
+	if job.Attempts >= dripMaxAttempts {
+		return errors.Join(sendErr, df.Store.DeleteJob(job.ID))
+	}
+
+	retryDelay := dripRetryDelay
+	var floodErr tb.FloodError
+	if errors.As(sendErr, &floodErr) {
+		retryDelay = time.Duration(floodErr.RetryAfter) * time.Second
+	}
+
+	job.SendAt = time.Now().Add(retryDelay)
+	return errors.Join(sendErr, df.Store.SaveJob(job))
+}
@@ -0,0 +1,193 @@
+package tgfun
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DripJobStore - scheduled follow-ups storage backend
+type DripJobStore interface {
+	// insert or replace job by ID
+	SaveJob(job DripJob) error
+	DeleteJob(jobID string) error
+	ListUserJobs(telegramUserID int64) ([]DripJob, error)
+	// returns jobs with SendAt before until, earliest first
+	ListDueJobs(until time.Time, limit int) ([]DripJob, error)
+}
+
+// MemoryDripJobStore - in-memory jobs storage, lost on restart
+type MemoryDripJobStore struct {
+	locker sync.Mutex
+	jobs   map[string]DripJob
+}
+
+func NewMemoryDripJobStore() *MemoryDripJobStore {
+	return &MemoryDripJobStore{jobs: map[string]DripJob{}}
+}
+
+func (s *MemoryDripJobStore) SaveJob(job DripJob) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *MemoryDripJobStore) DeleteJob(jobID string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	delete(s.jobs, jobID)
+	return nil
+}
+
+func (s *MemoryDripJobStore) ListUserJobs(telegramUserID int64) ([]DripJob, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	jobs := []DripJob{}
+	for _, job := range s.jobs {
+		if job.TelegramUserID == telegramUserID {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (s *MemoryDripJobStore) ListDueJobs(until time.Time, limit int) ([]DripJob, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	jobs := []DripJob{}
+	for _, job := range s.jobs {
+		if job.SendAt.Before(until) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].SendAt.Before(jobs[j].SendAt)
+	})
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+// SQLDripJobStore - jobs storage in MySQL, PostgreSQL or SQLite table.
+// Table schemas can be found in features/drip_jobs*.sql
+type SQLDripJobStore struct {
+	db      *sql.DB
+	table   string // quoted
+	dialect sqlDialect
+}
+
+func NewMySQLDripJobStore(db *sql.DB, tableName string) *SQLDripJobStore {
+	return newSQLDripJobStore(db, tableName, sqlDialectMySQL)
+}
+
+func NewPostgresDripJobStore(db *sql.DB, tableName string) *SQLDripJobStore {
+	return newSQLDripJobStore(db, tableName, sqlDialectPostgres)
+}
+
+func NewSQLiteDripJobStore(db *sql.DB, tableName string) *SQLDripJobStore {
+	return newSQLDripJobStore(db, tableName, sqlDialectSQLite)
+}
+
+func newSQLDripJobStore(db *sql.DB, tableName string, dialect sqlDialect) *SQLDripJobStore {
+	return &SQLDripJobStore{
+		db:      db,
+		table:   dialect.quoteIdent(tableName),
+		dialect: dialect,
+	}
+}
+
+const sqlDripJobColumns = "id,tid,event_id,send_at,cancel_on,attempts"
+
+func (s *SQLDripJobStore) SaveJob(job DripJob) error {
+	cancelOn, err := json.Marshal(job.CancelOnEvents)
+	if err != nil {
+		return errors.New("failed to encode job: " + err.Error())
+	}
+
+	sqlQuery := s.dialect.rebind(
+		"INSERT INTO " + s.table + " (" + sqlDripJobColumns + ") VALUES (?,?,?,?,?,?)" +
+			s.dialect.upsert("id", "tid", "event_id", "send_at", "cancel_on", "attempts"),
+	)
+	_, err = s.db.Exec(
+		sqlQuery,
+		job.ID,
+		job.TelegramUserID,
+		job.EventID,
+		job.SendAt.Unix(),
+		string(cancelOn),
+		job.Attempts,
+	)
+	if err != nil {
+		return errors.New("failed to save job: " + err.Error())
+	}
+	return nil
+}
+
+func (s *SQLDripJobStore) DeleteJob(jobID string) error {
+	sqlQuery := s.dialect.rebind("DELETE FROM " + s.table + " WHERE id=?")
+	if _, err := s.db.Exec(sqlQuery, jobID); err != nil {
+		return errors.New("failed to delete job: " + err.Error())
+	}
+	return nil
+}
+
+func (s *SQLDripJobStore) ListUserJobs(telegramUserID int64) ([]DripJob, error) {
+	sqlQuery := s.dialect.rebind(
+		"SELECT " + sqlDripJobColumns + " FROM " + s.table + " WHERE tid=?",
+	)
+	return s.selectJobs(sqlQuery, telegramUserID)
+}
+
+func (s *SQLDripJobStore) ListDueJobs(until time.Time, limit int) ([]DripJob, error) {
+	sqlQuery := s.dialect.rebind(
+		"SELECT " + sqlDripJobColumns + " FROM " + s.table +
+			" WHERE send_at<? ORDER BY send_at LIMIT ?",
+	)
+	return s.selectJobs(sqlQuery, until.Unix(), limit)
+}
+
+func (s *SQLDripJobStore) selectJobs(sqlQuery string, args ...interface{}) ([]DripJob, error) {
+	rows, err := s.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, errors.New("failed to select jobs: " + err.Error())
+	}
+	defer rows.Close()
+
+	jobs := []DripJob{}
+	for rows.Next() {
+		var job DripJob
+		var sendAt int64
+		var cancelOn string
+		err := rows.Scan(
+			&job.ID,
+			&job.TelegramUserID,
+			&job.EventID,
+			&sendAt,
+			&cancelOn,
+			&job.Attempts,
+		)
+		if err != nil {
+			return nil, errors.New("failed to scan job: " + err.Error())
+		}
+
+		job.SendAt = time.Unix(sendAt, 0)
+		if err := json.Unmarshal([]byte(cancelOn), &job.CancelOnEvents); err != nil {
+			return nil, errors.New("failed to decode job: " + err.Error())
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("failed to select jobs: " + err.Error())
+	}
+	return jobs, nil
+}
@@ -0,0 +1,61 @@
+package tgfun
+
+import (
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+)
+
+func TestParseDelay(t *testing.T) {
+	// given
+	cases := map[string]time.Duration{
+		"30m":   30 * time.Minute,
+		"24h":   24 * time.Hour,
+		"3d":    3 * durationDay,
+		"1d12h": durationDay + 12*time.Hour,
+	}
+
+	for delay, expected := range cases {
+		// when
+		d, err := parseDelay(delay)
+
+		// then
+		require.NoError(t, err, delay)
+		assert.Equal(t, expected, d, delay)
+	}
+
+	_, err := parseDelay("xd")
+	assert.Error(t, err)
+}
+
+func TestDripSchedulesAndCancelsFollowUps(t *testing.T) {
+	// given
+	store := NewMemoryDripJobStore()
+	feature := DripFeature{Store: store}
+	offer := FunnelEvent{
+		Message: EventMessage{Text: "offer"},
+		FollowUps: []FollowUp{
+			{EventID: "reminder", Delay: "1d", CancelOnEvents: []string{"paid"}},
+		},
+	}
+
+	// when
+	require.NoError(t, feature.onEventDelivered(100, "offer", offer))
+
+	// then
+	jobs, err := store.ListUserJobs(100)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "reminder", jobs[0].EventID)
+	assert.True(t, jobs[0].SendAt.After(time.Now().Add(23*time.Hour)))
+
+	// when
+	require.NoError(t, feature.onEventDelivered(100, "paid", FunnelEvent{}))
+
+	// then
+	jobs, err = store.ListUserJobs(100)
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
+}
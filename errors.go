@@ -0,0 +1,114 @@
+package tgfun
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// ErrorStage - stage of event processing, where error occurred
+type ErrorStage string
+
+const (
+	ErrorStageBuild      ErrorStage = "build"      // message building, e.g. missing file
+	ErrorStageSend       ErrorStage = "send"       // telegram API requests
+	ErrorStageCache      ErrorStage = "cache"      // uploaded files cache
+	ErrorStageLocker     ErrorStage = "locker"     // subscription check
+	ErrorStageConversion ErrorStage = "conversion" // conversion callbacks
+	ErrorStageDrip       ErrorStage = "drip"       // follow-ups scheduling
+	ErrorStageBroadcast  ErrorStage = "broadcast"  // broadcast sending
+	ErrorStageSession    ErrorStage = "session"    // user session load and save
+	ErrorStageReload     ErrorStage = "reload"     // script file watch and reload
+	ErrorStageMetrics    ErrorStage = "metrics"    // metrics response writing
+	ErrorStageAnalytics  ErrorStage = "analytics"  // event log records
+	ErrorStageHandle     ErrorStage = "handle"     // other errors of update handling
+)
+
+// ErrorEvent - error of funnel event processing
+type ErrorEvent struct {
+	UserID  int64  // 0 when error is not related to user
+	EventID string // empty when error is not related to event
+	Stage   ErrorStage
+	Err     error
+}
+
+func (e ErrorEvent) Error() string {
+	if e.EventID == "" {
+		return fmt.Sprintf("%s: %s", e.Stage, e.Err)
+	}
+	return fmt.Sprintf("%s event %q: %s", e.Stage, e.EventID, e.Err)
+}
+
+func (e ErrorEvent) Unwrap() error {
+	return e.Err
+}
+
+// logs errors and passes them to the funnel error hook
+type errorReporter struct {
+	logger  *slog.Logger
+	onError func(ErrorEvent)
+	metrics *funnelMetrics
+}
+
+// OnError - set callback for errors of event processing,
+// e.g. to route them to alerting. Errors are logged anyway
+func (f *Funnel) OnError(cb func(ErrorEvent)) {
+	f.errReporter.onError = cb
+}
+
+// SetLogger - set funnel logger, slog.Default is used by default
+func (f *Funnel) SetLogger(logger *slog.Logger) {
+	f.errReporter.logger = logger
+}
+
+// nil reporter is valid and writes to the default logger
+func (r *errorReporter) getLogger() *slog.Logger {
+	if r == nil || r.logger == nil {
+		return slog.Default()
+	}
+	return r.logger
+}
+
+func (r *errorReporter) report(e ErrorEvent) {
+	r.getLogger().Error(
+		e.Err.Error(),
+		"stage", string(e.Stage),
+		"userID", e.UserID,
+		"eventID", e.EventID,
+	)
+
+	if r == nil {
+		return
+	}
+	r.metrics.onError(e.Stage)
+	if r.onError != nil {
+		r.onError(e)
+	}
+}
+
+// handles errors returned by update handlers
+func (r *errorReporter) handleBotError(err error, ctx tb.Context) {
+	var e ErrorEvent
+	if !errors.As(err, &e) {
+		e = ErrorEvent{Stage: ErrorStageHandle, Err: err}
+		if ctx != nil && ctx.Sender() != nil {
+			e.UserID = ctx.Sender().ID
+		}
+	}
+	r.report(e)
+}
+
+func (q *QueryHandler) newError(stage ErrorStage, err error) ErrorEvent {
+	return ErrorEvent{
+		UserID:  q.telegramUserID,
+		EventID: q.EventMessageID,
+		Stage:   stage,
+		Err:     err,
+	}
+}
+
+func (q *QueryHandler) reportError(stage ErrorStage, err error) {
+	q.errReporter.report(q.newError(stage, err))
+}
@@ -0,0 +1,33 @@
+package tgfun
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestHandleBotError(t *testing.T) {
+	// given
+	var reported []ErrorEvent
+	r := &errorReporter{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		onError: func(e ErrorEvent) { reported = append(reported, e) },
+	}
+	sendErr := errors.New("bad request")
+	eventErr := ErrorEvent{UserID: 1, EventID: "offer", Stage: ErrorStageSend, Err: sendErr}
+
+	// when
+	r.handleBotError(fmt.Errorf("get query handler: %w", eventErr), nil)
+	r.handleBotError(errors.New("unknown"), nil)
+
+	// then
+	assert.Len(t, reported, 2)
+	assert.Equal(t, eventErr, reported[0])
+	assert.True(t, errors.Is(reported[0], sendErr))
+	assert.Equal(t, ErrorStageHandle, reported[1].Stage)
+	assert.Equal(t, `send event "offer": bad request`, eventErr.Error())
+}
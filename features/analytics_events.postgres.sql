@@ -0,0 +1,13 @@
+CREATE TABLE "funnel_analytics_events" (
+  "id" bigserial PRIMARY KEY,
+  "tid" bigint NOT NULL,
+  "kind" varchar(16) NOT NULL,
+  "event_id" varchar(128) NOT NULL DEFAULT '',
+  "button" varchar(128) NOT NULL DEFAULT '',
+  "conversion" varchar(128) NOT NULL DEFAULT '',
+  "utm_source" varchar(128) NOT NULL DEFAULT '',
+  "utm_campaign" varchar(128) NOT NULL DEFAULT '',
+  "created_at" bigint NOT NULL
+);
+CREATE INDEX "index_analytics_tid" ON "funnel_analytics_events" ("tid");
+CREATE INDEX "index_analytics_created_at" ON "funnel_analytics_events" ("created_at");
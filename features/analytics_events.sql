@@ -0,0 +1,14 @@
+CREATE TABLE `funnel_analytics_events` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `tid` bigint(20) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `event_id` varchar(128) NOT NULL DEFAULT '',
+  `button` varchar(128) NOT NULL DEFAULT '',
+  `conversion` varchar(128) NOT NULL DEFAULT '',
+  `utm_source` varchar(128) NOT NULL DEFAULT '',
+  `utm_campaign` varchar(128) NOT NULL DEFAULT '',
+  `created_at` bigint(20) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_tid` (`tid`),
+  KEY `index_created_at` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
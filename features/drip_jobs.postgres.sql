@@ -0,0 +1,10 @@
+CREATE TABLE "funnel_drip_jobs" (
+  "id" varchar(191) PRIMARY KEY,
+  "tid" bigint NOT NULL,
+  "event_id" varchar(128) NOT NULL,
+  "send_at" bigint NOT NULL,
+  "cancel_on" text NOT NULL,
+  "attempts" integer NOT NULL DEFAULT 0
+);
+CREATE INDEX "index_drip_tid" ON "funnel_drip_jobs" ("tid");
+CREATE INDEX "index_drip_send_at" ON "funnel_drip_jobs" ("send_at");
@@ -0,0 +1,11 @@
+CREATE TABLE `funnel_drip_jobs` (
+  `id` varchar(191) NOT NULL,
+  `tid` bigint(20) NOT NULL,
+  `event_id` varchar(128) NOT NULL,
+  `send_at` bigint(20) NOT NULL,
+  `cancel_on` text NOT NULL,
+  `attempts` int(11) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  KEY `index_tid` (`tid`),
+  KEY `index_send_at` (`send_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
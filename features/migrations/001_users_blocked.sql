@@ -0,0 +1,3 @@
+-- mark users who blocked the bot, used by broadcasts
+ALTER TABLE `funnel_users`
+  ADD COLUMN `blocked` tinyint(1) NOT NULL DEFAULT 0 AFTER `tgname`;
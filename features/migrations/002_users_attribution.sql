@@ -0,0 +1,12 @@
+-- first-touch and last-touch UTM attribution
+ALTER TABLE `funnel_users`
+  ADD COLUMN `first_utm_source` varchar(128) NOT NULL DEFAULT '',
+  ADD COLUMN `first_utm_campaign` varchar(128) NOT NULL DEFAULT '',
+  ADD COLUMN `first_utm_content` varchar(128) NOT NULL DEFAULT '',
+  ADD COLUMN `first_yclid` varchar(64) NOT NULL DEFAULT '',
+  ADD COLUMN `first_touch_at` bigint(20) NOT NULL DEFAULT 0,
+  ADD COLUMN `last_utm_source` varchar(128) NOT NULL DEFAULT '',
+  ADD COLUMN `last_utm_campaign` varchar(128) NOT NULL DEFAULT '',
+  ADD COLUMN `last_utm_content` varchar(128) NOT NULL DEFAULT '',
+  ADD COLUMN `last_yclid` varchar(64) NOT NULL DEFAULT '',
+  ADD COLUMN `last_touch_at` bigint(20) NOT NULL DEFAULT 0;
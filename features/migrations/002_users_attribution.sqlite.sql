@@ -0,0 +1,11 @@
+-- first-touch and last-touch UTM attribution
+ALTER TABLE "funnel_users" ADD COLUMN "first_utm_source" varchar(128) NOT NULL DEFAULT '';
+ALTER TABLE "funnel_users" ADD COLUMN "first_utm_campaign" varchar(128) NOT NULL DEFAULT '';
+ALTER TABLE "funnel_users" ADD COLUMN "first_utm_content" varchar(128) NOT NULL DEFAULT '';
+ALTER TABLE "funnel_users" ADD COLUMN "first_yclid" varchar(64) NOT NULL DEFAULT '';
+ALTER TABLE "funnel_users" ADD COLUMN "first_touch_at" integer NOT NULL DEFAULT 0;
+ALTER TABLE "funnel_users" ADD COLUMN "last_utm_source" varchar(128) NOT NULL DEFAULT '';
+ALTER TABLE "funnel_users" ADD COLUMN "last_utm_campaign" varchar(128) NOT NULL DEFAULT '';
+ALTER TABLE "funnel_users" ADD COLUMN "last_utm_content" varchar(128) NOT NULL DEFAULT '';
+ALTER TABLE "funnel_users" ADD COLUMN "last_yclid" varchar(64) NOT NULL DEFAULT '';
+ALTER TABLE "funnel_users" ADD COLUMN "last_touch_at" integer NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- language chosen by user in multi-language funnels
+ALTER TABLE `funnel_users`
+  ADD COLUMN `language` varchar(16) NOT NULL DEFAULT '' AFTER `blocked`;
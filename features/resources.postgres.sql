@@ -0,0 +1,8 @@
+CREATE TABLE "funnel_resources" (
+  "path" varchar(255) PRIMARY KEY,
+  "file_id" varchar(255) NOT NULL,
+  "file_unique_id" varchar(255) NOT NULL DEFAULT '',
+  "size" bigint NOT NULL DEFAULT 0,
+  "hash" varchar(32) NOT NULL DEFAULT '',
+  "expire_at" bigint NOT NULL DEFAULT 0
+);
@@ -0,0 +1,9 @@
+CREATE TABLE `funnel_resources` (
+  `path` varchar(255) NOT NULL,
+  `file_id` varchar(255) NOT NULL,
+  `file_unique_id` varchar(255) NOT NULL DEFAULT '',
+  `size` bigint(20) NOT NULL DEFAULT 0,
+  `hash` varchar(32) NOT NULL DEFAULT '',
+  `expire_at` bigint(20) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`path`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
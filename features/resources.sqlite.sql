@@ -0,0 +1,8 @@
+CREATE TABLE "funnel_resources" (
+  "path" text PRIMARY KEY,
+  "file_id" text NOT NULL,
+  "file_unique_id" text NOT NULL DEFAULT '',
+  "size" integer NOT NULL DEFAULT 0,
+  "hash" text NOT NULL DEFAULT '',
+  "expire_at" integer NOT NULL DEFAULT 0
+);
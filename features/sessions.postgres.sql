@@ -0,0 +1,5 @@
+CREATE TABLE "funnel_sessions" (
+  "tid" bigint PRIMARY KEY,
+  "data" text NOT NULL,
+  "updated_at" bigint NOT NULL DEFAULT 0
+);
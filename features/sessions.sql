@@ -0,0 +1,6 @@
+CREATE TABLE `funnel_sessions` (
+  `tid` bigint(20) NOT NULL,
+  `data` mediumtext NOT NULL,
+  `updated_at` bigint(20) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`tid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,5 @@
+CREATE TABLE "funnel_sessions" (
+  "tid" integer PRIMARY KEY,
+  "data" text NOT NULL,
+  "updated_at" integer NOT NULL DEFAULT 0
+);
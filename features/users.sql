@@ -3,6 +3,18 @@ CREATE TABLE `funnel_users` (
   `tid` bigint(20) NOT NULL DEFAULT 0,
   `name` varchar(64) NOT NULL DEFAULT 'anonymous',
   `tgname` varchar(64) NOT NULL DEFAULT '',
+  `blocked` tinyint(1) NOT NULL DEFAULT 0,
+  `language` varchar(16) NOT NULL DEFAULT '',
+  `first_utm_source` varchar(128) NOT NULL DEFAULT '',
+  `first_utm_campaign` varchar(128) NOT NULL DEFAULT '',
+  `first_utm_content` varchar(128) NOT NULL DEFAULT '',
+  `first_yclid` varchar(64) NOT NULL DEFAULT '',
+  `first_touch_at` bigint(20) NOT NULL DEFAULT 0,
+  `last_utm_source` varchar(128) NOT NULL DEFAULT '',
+  `last_utm_campaign` varchar(128) NOT NULL DEFAULT '',
+  `last_utm_content` varchar(128) NOT NULL DEFAULT '',
+  `last_yclid` varchar(64) NOT NULL DEFAULT '',
+  `last_touch_at` bigint(20) NOT NULL DEFAULT 0,
   PRIMARY KEY (`id`),
   KEY `index_tid` (`tid`)
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,19 @@
+CREATE TABLE "funnel_users" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "tid" integer NOT NULL DEFAULT 0,
+  "name" varchar(64) NOT NULL DEFAULT 'anonymous',
+  "tgname" varchar(64) NOT NULL DEFAULT '',
+  "blocked" boolean NOT NULL DEFAULT 0,
+  "language" varchar(16) NOT NULL DEFAULT '',
+  "first_utm_source" varchar(128) NOT NULL DEFAULT '',
+  "first_utm_campaign" varchar(128) NOT NULL DEFAULT '',
+  "first_utm_content" varchar(128) NOT NULL DEFAULT '',
+  "first_yclid" varchar(64) NOT NULL DEFAULT '',
+  "first_touch_at" integer NOT NULL DEFAULT 0,
+  "last_utm_source" varchar(128) NOT NULL DEFAULT '',
+  "last_utm_campaign" varchar(128) NOT NULL DEFAULT '',
+  "last_utm_content" varchar(128) NOT NULL DEFAULT '',
+  "last_yclid" varchar(64) NOT NULL DEFAULT '',
+  "last_touch_at" integer NOT NULL DEFAULT 0
+);
+CREATE INDEX "index_tid" ON "funnel_users" ("tid");
@@ -0,0 +1,274 @@
+package tgfun
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type graphNodeKind string
+
+const (
+	graphNodeEvent     graphNodeKind = "event"
+	graphNodeLink      graphNodeKind = "link"
+	graphNodeUserInput graphNodeKind = "input"
+)
+
+type graphEdgeKind string
+
+const (
+	graphEdgeButton   graphEdgeKind = "button"
+	graphEdgeBacklink graphEdgeKind = "backlink"
+	graphEdgeLocker   graphEdgeKind = "locker"
+	graphEdgeInput    graphEdgeKind = "input"
+	graphEdgeFollowUp graphEdgeKind = "followUp"
+)
+
+const userInputNodeKey = "user input"
+
+type graphNode struct {
+	Key   string
+	Label string
+	Kind  graphNodeKind
+}
+
+type graphEdge struct {
+	From  string
+	To    string
+	Label string
+	Kind  graphEdgeKind
+}
+
+type funnelGraph struct {
+	nodes   []graphNode
+	edges   []graphEdge
+	nodeIDs map[string]string // node key -> short ID
+}
+
+// RenderDOT - funnel script as Graphviz DOT graph
+func (s FunnelScript) RenderDOT() string {
+	return buildFunnelGraph(s, nil).dot()
+}
+
+// RenderMermaid - funnel script as Mermaid flowchart
+func (s FunnelScript) RenderMermaid() string {
+	return buildFunnelGraph(s, nil).mermaid()
+}
+
+// RenderDOT - funnel as Graphviz DOT graph including enabled features
+func (f *Funnel) RenderDOT() string {
+	return buildFunnelGraph(f.getScript(), f.features.UserInput).dot()
+}
+
+// RenderMermaid - funnel as Mermaid flowchart including enabled features
+func (f *Funnel) RenderMermaid() string {
+	return buildFunnelGraph(f.getScript(), f.features.UserInput).mermaid()
+}
+
+func buildFunnelGraph(script FunnelScript, userInput *UserInputFeature) *funnelGraph {
+	g := &funnelGraph{nodeIDs: map[string]string{}}
+
+	eventIDs := script.eventIDs()
+	for _, eventID := range eventIDs {
+		g.addNode(eventID, getEventGraphLabel(eventID, script[eventID]), graphNodeEvent)
+	}
+
+	for _, eventID := range eventIDs {
+		event := script[eventID]
+
+		for _, btn := range event.Message.Buttons {
+			if btn.SkipRenderInGraph {
+				continue
+			}
+
+			if btn.URL == "" {
+				if _, isExists := script[btn.NextMessageID]; isExists {
+					g.addEdge(eventID, btn.NextMessageID, btn.Text, graphEdgeButton)
+				}
+				continue
+			}
+
+			if backlinkID, isBacklink := findBacklinkEventID(script, btn.URL); isBacklink {
+				g.addEdge(eventID, backlinkID, btn.Text, graphEdgeBacklink)
+				continue
+			}
+
+			g.addNode(btn.URL, btn.URL, graphNodeLink)
+			g.addEdge(eventID, btn.URL, btn.Text, graphEdgeButton)
+		}
+
+		locker := event.SubscriptionLocker
+		if locker.Enabled {
+			if _, isExists := script[locker.LockerMessageID]; isExists {
+				g.addEdge(eventID, locker.LockerMessageID, "not subscribed", graphEdgeLocker)
+			}
+		}
+
+		for _, followUp := range event.FollowUps {
+			if _, isExists := script[followUp.EventID]; isExists {
+				g.addEdge(eventID, followUp.EventID, "after "+followUp.Delay, graphEdgeFollowUp)
+			}
+		}
+	}
+
+	if userInput != nil {
+		g.addNode(userInputNodeKey, userInputNodeKey, graphNodeUserInput)
+
+		if _, isExists := script[userInput.InputVerifiedEventID]; isExists {
+			g.addEdge(userInputNodeKey, userInput.InputVerifiedEventID, "valid", graphEdgeInput)
+		}
+		if _, isExists := script[userInput.InvalidFormatEventID]; isExists {
+			g.addEdge(userInputNodeKey, userInput.InvalidFormatEventID, "invalid", graphEdgeInput)
+		}
+	}
+	return g
+}
+
+func getEventGraphLabel(eventID string, event FunnelEvent) string {
+	messageType := string(getMessageType(event.Message))
+	if event.Message.Callback != nil || event.Message.CallbackWithParam != nil {
+		messageType = "dynamic"
+	}
+	return fmt.Sprintf("%s\n(%s)", eventID, messageType)
+}
+
+// backlink is a link to the bot with start payload, that leads to event.
+// example: https://t.me/bot?start=offer_back
+func findBacklinkEventID(script FunnelScript, btnURL string) (string, bool) {
+	u, err := url.Parse(btnURL)
+	if err != nil {
+		return "", false
+	}
+
+	startPayload := u.Query().Get("start")
+	if startPayload == "" {
+		return "", false
+	}
+
+	payload, err := FilterUserPayload(startPayload)
+	if err != nil || payload.BackLinkEventID == "" {
+		return "", false
+	}
+
+	// the same lookup order as in QueryHandler.handleMessage
+	for _, eventID := range []string{
+		strings.ToLower(payload.BackLinkEventID),
+		payload.BackLinkEventID,
+	} {
+		if _, isExists := script[eventID]; isExists {
+			return eventID, true
+		}
+	}
+	return "", false
+}
+
+func (g *funnelGraph) addNode(key, label string, kind graphNodeKind) {
+	if _, isExists := g.nodeIDs[key]; isExists {
+		return
+	}
+
+	g.nodeIDs[key] = fmt.Sprintf("n%v", len(g.nodes))
+	g.nodes = append(g.nodes, graphNode{
+		Key:   key,
+		Label: label,
+		Kind:  kind,
+	})
+}
+
+func (g *funnelGraph) addEdge(from, to, label string, kind graphEdgeKind) {
+	g.edges = append(g.edges, graphEdge{
+		From:  from,
+		To:    to,
+		Label: label,
+		Kind:  kind,
+	})
+}
+
+func (g *funnelGraph) dot() string {
+	var b strings.Builder
+	b.WriteString("digraph funnel {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box, style=rounded];\n")
+
+	for _, node := range g.nodes {
+		var attrs string
+		switch node.Kind {
+		case graphNodeLink:
+			attrs = ", shape=note"
+		case graphNodeUserInput:
+			attrs = ", shape=ellipse"
+		}
+
+		fmt.Fprintf(
+			&b, "\t%s [label=%s%s];\n",
+			g.nodeIDs[node.Key], dotQuote(node.Label), attrs,
+		)
+	}
+
+	for _, edge := range g.edges {
+		var attrs string
+		switch edge.Kind {
+		case graphEdgeBacklink:
+			attrs = ", style=dashed"
+		case graphEdgeLocker:
+			attrs = ", style=dotted, color=red"
+		case graphEdgeInput:
+			attrs = ", style=dashed, color=blue"
+		case graphEdgeFollowUp:
+			attrs = ", style=dashed, color=darkgreen"
+		}
+
+		fmt.Fprintf(
+			&b, "\t%s -> %s [label=%s%s];\n",
+			g.nodeIDs[edge.From], g.nodeIDs[edge.To], dotQuote(edge.Label), attrs,
+		)
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (g *funnelGraph) mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+
+	for _, node := range g.nodes {
+		label := mermaidQuote(node.Label)
+
+		switch node.Kind {
+		default:
+			fmt.Fprintf(&b, "\t%s(%s)\n", g.nodeIDs[node.Key], label)
+		case graphNodeLink:
+			fmt.Fprintf(&b, "\t%s>%s]\n", g.nodeIDs[node.Key], label)
+		case graphNodeUserInput:
+			fmt.Fprintf(&b, "\t%s([%s])\n", g.nodeIDs[node.Key], label)
+		}
+	}
+
+	for _, edge := range g.edges {
+		arrow := "-->"
+		switch edge.Kind {
+		case graphEdgeBacklink, graphEdgeLocker, graphEdgeInput, graphEdgeFollowUp:
+			arrow = "-.->"
+		}
+
+		fmt.Fprintf(
+			&b, "\t%s %s|%s| %s\n",
+			g.nodeIDs[edge.From], arrow, mermaidQuote(edge.Label), g.nodeIDs[edge.To],
+		)
+	}
+	return b.String()
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+func mermaidQuote(s string) string {
+	s = strings.ReplaceAll(s, `"`, "#quot;")
+	s = strings.ReplaceAll(s, "\n", "<br/>")
+	return `"` + s + `"`
+}
@@ -0,0 +1,80 @@
+package tgfun
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func getGraphTestScript() FunnelScript {
+	return FunnelScript{
+		"/start": {Message: EventMessage{
+			Text: "hello",
+			Buttons: []MessageButton{
+				{Text: "next", NextMessageID: "offer"},
+				{Text: "hidden", NextMessageID: "offer", SkipRenderInGraph: true},
+			},
+		}},
+		"offer": {
+			Message: EventMessage{
+				Image: "offer.png",
+				Buttons: []MessageButton{
+					{Text: "back", URL: "https://t.me/bot?start=Offer_back"},
+				},
+			},
+			SubscriptionLocker: EventLocker{
+				Enabled:         true,
+				LockerMessageID: "subscribe",
+			},
+		},
+		"subscribe": {Message: EventMessage{Text: "subscribe"}},
+	}
+}
+
+func TestRenderDOT(t *testing.T) {
+	// given
+	script := getGraphTestScript()
+
+	// when
+	dot := script.RenderDOT()
+
+	// then
+	expected := `digraph funnel {
+	rankdir=LR;
+	node [shape=box, style=rounded];
+	n0 [label="/start\n(text)"];
+	n1 [label="offer\n(photo)"];
+	n2 [label="subscribe\n(text)"];
+	n0 -> n1 [label="next"];
+	n1 -> n1 [label="back", style=dashed];
+	n1 -> n2 [label="not subscribed", style=dotted, color=red];
+}
+`
+	assert.Equal(t, expected, dot)
+}
+
+func TestRenderMermaidWithUserInput(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, getGraphTestScript())
+	f.features.UserInput = &UserInputFeature{
+		InputVerifiedEventID: "offer",
+		InvalidFormatEventID: "subscribe",
+	}
+
+	// when
+	mermaid := f.RenderMermaid()
+
+	// then
+	expected := `flowchart LR
+	n0("/start<br/>(text)")
+	n1("offer<br/>(photo)")
+	n2("subscribe<br/>(text)")
+	n3(["user input"])
+	n0 -->|"next"| n1
+	n1 -.->|"back"| n1
+	n1 -.->|"not subscribed"| n2
+	n3 -.->|"valid"| n1
+	n3 -.->|"invalid"| n2
+`
+	assert.Equal(t, expected, mermaid)
+}
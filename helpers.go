@@ -5,7 +5,6 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
 	"net/url"
 	"regexp"
 	"strconv"
@@ -26,6 +25,10 @@ func getFilePath(localPath, pathRoot string) string {
 }
 
 func getMessageType(message EventMessage) MessageType {
+	if len(message.Album) > 0 {
+		return MessageTypeAlbum
+	}
+
 	if message.Image != "" {
 		return MessageTypePhoto
 	}
@@ -42,19 +45,52 @@ func getMessageType(message EventMessage) MessageType {
 		return MessageTypeAudio
 	}
 
+	if message.Voice.Path != "" {
+		return MessageTypeVoice
+	}
+
+	if message.VideoNote.Path != "" {
+		return MessageTypeVideoNote
+	}
+
+	if message.Animation.Path != "" {
+		return MessageTypeAnimation
+	}
+
+	if message.Sticker.Path != "" || message.Sticker.FileID != "" {
+		return MessageTypeSticker
+	}
+
 	return MessageTypeText
 }
 
+// returns local file from the resources cache, state.
+// false is returned when file not exists
+func (q *QueryHandler) getLocalFile(
+	localPath string,
+	filesRoot string,
+	messageType MessageType,
+) (tb.File, fileState, bool) {
+	filePath := getFilePath(localPath, filesRoot)
+	if !swissknife.IsFileExists(filePath) {
+		q.reportError(ErrorStageBuild, fmt.Errorf("%s file %q not found", messageType, filePath))
+		return tb.File{}, fileState{}, false
+	}
+
+	return q.resCache.Get(localPath), fileState{
+		IsUsed:        true,
+		Type:          messageType,
+		LocalFilePath: localPath,
+	}, true
+}
+
 // returns photo, state
 func (q *QueryHandler) getPhotoMessage(
 	message EventMessage,
 	filesRoot string,
 	telegramUserID int64,
 ) (interface{}, fileState) {
-	st := fileState{
-		Type:          MessageTypePhoto,
-		LocalFilePath: message.Image,
-	}
+	var st fileState
 
 	photo := &tb.Photo{}
 	if strings.Contains(message.Image, "http") {
@@ -63,20 +99,20 @@ func (q *QueryHandler) getPhotoMessage(
 	} else if message.Image == "parametric" {
 		// get image
 		if !q.Features.IsUserInputFeatureActive() {
-			log.Println("user input feature is disabled")
+			q.reportError(ErrorStageBuild, errors.New("user input feature is disabled"))
 			return message.Text, fileState{}
 		}
 
 		input, err := q.Features.UserInput.GetUserInputCallback(telegramUserID)
 		if err != nil {
-			log.Println("get user input:", err)
+			q.reportError(ErrorStageBuild, fmt.Errorf("get user input: %w", err))
 			return message.Text, fileState{}
 		}
 
 		if message.ImageData.ArgumentType != "userInput" {
-			log.Println(
-				"unknown image argument type:",
-				message.ImageData.ArgumentType,
+			q.reportError(
+				ErrorStageBuild,
+				fmt.Errorf("unknown image argument type: %q", message.ImageData.ArgumentType),
 			)
 		}
 
@@ -87,7 +123,7 @@ func (q *QueryHandler) getPhotoMessage(
 
 		imageData, err := swissknife.HttpGET(imageURL)
 		if err != nil {
-			log.Println("get image:", err)
+			q.reportError(ErrorStageBuild, fmt.Errorf("get image: %w", err))
 			return message.Text, fileState{}
 		}
 
@@ -95,13 +131,13 @@ func (q *QueryHandler) getPhotoMessage(
 		photo.File = tb.FromReader(reader)
 	} else {
 		// local image
-		filePath := getFilePath(message.Image, filesRoot)
-		if !swissknife.IsFileExists(filePath) {
+		file, fileSt, isExists := q.getLocalFile(message.Image, filesRoot, MessageTypePhoto)
+		if !isExists {
 			return message.Text, fileState{} // use plain text, when file not exists
 		}
 
-		photo.File = q.resCache.Get(message.Image)
-		st.IsUsed = true
+		photo.File = file
+		st = fileSt
 	}
 
 	// add message text
@@ -115,19 +151,18 @@ func getTextMessage(message EventMessage) interface{} {
 	return message.Text
 }
 
-// returns doc, is local
+// returns doc, state
 func (q *QueryHandler) getDocumentMessage(
 	message EventMessage,
 	filesRoot string,
 ) (interface{}, fileState) {
-	st := fileState{
-		IsUsed:        true,
-		Type:          MessageTypeDocument,
-		LocalFilePath: message.File.Path,
+	file, st, isExists := q.getLocalFile(message.File.Path, filesRoot, MessageTypeDocument)
+	if !isExists {
+		return getTextMessage(message), fileState{}
 	}
 
 	doc := &tb.Document{
-		File:     q.resCache.Get(message.File.Path),
+		File:     file,
 		FileName: message.File.Name,
 	}
 	if message.Text != "" {
@@ -139,7 +174,7 @@ func (q *QueryHandler) getDocumentMessage(
 		previewPath := getFilePath(message.File.PreviewImagePath, filesRoot)
 
 		if !swissknife.IsFileExists(previewPath) {
-			log.Printf("file preview %q not exists, skip\n", previewPath)
+			q.reportError(ErrorStageBuild, fmt.Errorf("file preview %q not exists, skip", previewPath))
 		} else {
 			doc.Thumbnail = &tb.Photo{
 				File: q.resCache.Get(message.File.PreviewImagePath),
@@ -150,29 +185,17 @@ func (q *QueryHandler) getDocumentMessage(
 	return doc, st
 }
 
-// returns doc, is local
+// returns audio, state
 func (q *QueryHandler) getAudioMessage(
 	message EventMessage,
 	filesRoot string,
 ) (interface{}, fileState) {
-	st := fileState{
-		IsUsed:        true,
-		Type:          MessageTypeAudio,
-		LocalFilePath: message.Audio.Path,
-	}
-
-	fileFullPath := getFilePath(
-		message.Audio.Path,
-		filesRoot,
-	)
-	if !swissknife.IsFileExists(fileFullPath) {
-		log.Printf("audio file %q not found\n", fileFullPath)
+	file, st, isExists := q.getLocalFile(message.Audio.Path, filesRoot, MessageTypeAudio)
+	if !isExists {
 		return getTextMessage(message), fileState{}
 	}
 
-	audio := &tb.Audio{
-		File: q.resCache.Get(message.Audio.Path),
-	}
+	audio := &tb.Audio{File: file}
 
 	if message.Audio.Name != "" {
 		audio.FileName = message.Audio.Name
@@ -187,27 +210,18 @@ func (q *QueryHandler) getAudioMessage(
 	return audio, st
 }
 
-// returns doc, is local
+// returns video, state
 func (q *QueryHandler) getVideoMessage(
 	message EventMessage,
 	filesRoot string,
 ) (interface{}, fileState) {
-	st := fileState{
-		IsUsed:        true,
-		Type:          MessageTypeVideo,
-		LocalFilePath: message.Video.Path,
-	}
-
-	videoPath := getFilePath(
-		message.Video.Path,
-		filesRoot,
-	)
-	if !swissknife.IsFileExists(videoPath) {
+	file, st, isExists := q.getLocalFile(message.Video.Path, filesRoot, MessageTypeVideo)
+	if !isExists {
 		return "Failed to upload video for delivery. Try again later, sorry", fileState{}
 	}
 
 	video := &tb.Video{
-		File:     tb.FromDisk(videoPath),
+		File:     file,
 		Width:    message.Video.Width,
 		Height:   message.Video.Height,
 		FileName: "video.mp4",
@@ -223,7 +237,7 @@ func (q *QueryHandler) getVideoMessage(
 		)
 
 		if !swissknife.IsFileExists(previewPath) {
-			log.Printf("file preview %q not exists, skip\n", previewPath)
+			q.reportError(ErrorStageBuild, fmt.Errorf("file preview %q not exists, skip", previewPath))
 		} else {
 			video.Thumbnail = &tb.Photo{
 				File:   q.resCache.Get(message.Video.PreviewImagePath),
@@ -235,6 +249,95 @@ func (q *QueryHandler) getVideoMessage(
 	return video, st
 }
 
+// returns voice, state
+func (q *QueryHandler) getVoiceMessage(
+	message EventMessage,
+	filesRoot string,
+) (interface{}, fileState) {
+	file, st, isExists := q.getLocalFile(message.Voice.Path, filesRoot, MessageTypeVoice)
+	if !isExists {
+		return getTextMessage(message), fileState{}
+	}
+
+	voice := &tb.Voice{
+		File:     file,
+		Duration: message.Voice.Duration,
+	}
+	if message.Text != "" {
+		voice.Caption = message.Text
+	}
+
+	return voice, st
+}
+
+// returns video note, state
+func (q *QueryHandler) getVideoNoteMessage(
+	message EventMessage,
+	filesRoot string,
+) (interface{}, fileState) {
+	file, st, isExists := q.getLocalFile(message.VideoNote.Path, filesRoot, MessageTypeVideoNote)
+	if !isExists {
+		return getTextMessage(message), fileState{}
+	}
+
+	// video note can't have caption
+	videoNote := &tb.VideoNote{
+		File:     file,
+		Duration: message.VideoNote.Duration,
+		Length:   message.VideoNote.Length,
+	}
+
+	return videoNote, st
+}
+
+// returns animation, state
+func (q *QueryHandler) getAnimationMessage(
+	message EventMessage,
+	filesRoot string,
+) (interface{}, fileState) {
+	var st fileState
+
+	animation := &tb.Animation{
+		Width:    message.Animation.Width,
+		Height:   message.Animation.Height,
+		Duration: message.Animation.Duration,
+	}
+	if isExternalFile(message.Animation.Path) {
+		animation.File = tb.FromURL(message.Animation.Path)
+	} else {
+		file, fileSt, isExists := q.getLocalFile(message.Animation.Path, filesRoot, MessageTypeAnimation)
+		if !isExists {
+			return getTextMessage(message), fileState{}
+		}
+
+		animation.File = file
+		st = fileSt
+	}
+	if message.Text != "" {
+		animation.Caption = message.Text
+	}
+
+	return animation, st
+}
+
+// returns sticker, state
+func (q *QueryHandler) getStickerMessage(
+	message EventMessage,
+	filesRoot string,
+) (interface{}, fileState) {
+	if message.Sticker.FileID != "" {
+		return &tb.Sticker{File: tb.File{FileID: message.Sticker.FileID}}, fileState{}
+	}
+
+	file, st, isExists := q.getLocalFile(message.Sticker.Path, filesRoot, MessageTypeSticker)
+	if !isExists {
+		return getTextMessage(message), fileState{}
+	}
+
+	// sticker can't have caption
+	return &tb.Sticker{File: file}, st
+}
+
 func addUtmTags(baseURL string, tags UTMTags) (string, error) {
 	if tags.Campaign == "" || tags.Source == "" {
 		return baseURL, nil
@@ -405,33 +508,31 @@ func LimitStringLen(str string, maxLength int) string {
 	return str[0:maxLength]
 }
 
+// telebot media type of message types, that are cached
+var cachedMediaTypes = map[MessageType]string{
+	MessageTypePhoto:     "photo",
+	MessageTypeDocument:  "document",
+	MessageTypeVideo:     "video",
+	MessageTypeAudio:     "audio",
+	MessageTypeVoice:     "voice",
+	MessageTypeVideoNote: "videoNote",
+	MessageTypeAnimation: "animation",
+	MessageTypeSticker:   "sticker",
+}
+
 func findFileInMessage(m *tb.Message, st fileState) (tb.File, bool) {
 	if m == nil {
 		return tb.File{}, false
 	}
 
-	switch st.Type {
-	default:
+	mediaType, isCached := cachedMediaTypes[st.Type]
+	if !isCached {
 		return tb.File{}, false // skip
-	case MessageTypeAudio:
-		if m.Audio == nil {
-			return tb.File{}, false
-		}
-		return m.Audio.File, true
-	case MessageTypePhoto:
-		if m.Photo == nil {
-			return tb.File{}, false
-		}
-		return m.Photo.File, true
-	case MessageTypeDocument:
-		if m.Document == nil {
-			return tb.File{}, false
-		}
-		return m.Document.File, true
-	case MessageTypeVideo:
-		if m.Video == nil {
-			return tb.File{}, false
-		}
-		return m.Video.File, true
 	}
+
+	media := m.Media()
+	if media == nil || media.MediaType() != mediaType {
+		return tb.File{}, false
+	}
+	return *media.MediaFile(), true
 }
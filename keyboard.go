@@ -0,0 +1,169 @@
+package tgfun
+
+import (
+	"fmt"
+	"sort"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// KeyboardKind - kind of event message buttons keyboard
+type KeyboardKind string
+
+const (
+	KeyboardInline KeyboardKind = ""       // buttons attached to the message
+	KeyboardReply  KeyboardKind = "reply"  // buttons at the bottom of the screen
+	KeyboardRemove KeyboardKind = "remove" // remove reply keyboard shown before
+)
+
+func (k KeyboardKind) isValid() bool {
+	switch k {
+	case KeyboardInline, KeyboardReply, KeyboardRemove:
+		return true
+	}
+	return false
+}
+
+// reply keyboard presses are sent as user messages,
+// so they are mapped back to script events
+type replyButtons struct {
+	texts    map[string]MessageButton // button text -> button
+	contact  *MessageButton
+	location *MessageButton
+}
+
+// first button wins on conflicts, they are reported by Validate
+func newReplyButtons(script FunnelScript) replyButtons {
+	b := replyButtons{texts: map[string]MessageButton{}}
+
+	for _, eventID := range script.eventIDs() {
+		msg := script[eventID].Message
+		if msg.Keyboard != KeyboardReply {
+			continue
+		}
+
+		for _, btn := range msg.Buttons {
+			btn := btn
+			switch {
+			case btn.RequestContact:
+				if b.contact == nil {
+					b.contact = &btn
+				}
+			case btn.RequestLocation:
+				if b.location == nil {
+					b.location = &btn
+				}
+			default:
+				for _, text := range btn.getTexts() {
+					if _, isExists := b.texts[text]; !isExists {
+						b.texts[text] = btn
+					}
+				}
+			}
+		}
+	}
+	return b
+}
+
+// handlers are registered anyway, because reloaded script can add request buttons
+func (f *Funnel) handleReplyButtons() {
+	f.bot.Handle(tb.OnContact, func(ctx tb.Context) error {
+		return f.handleRequestButton(ctx, f.getReplyButtons().contact)
+	})
+	f.bot.Handle(tb.OnLocation, func(ctx tb.Context) error {
+		return f.handleRequestButton(ctx, f.getReplyButtons().location)
+	})
+}
+
+func (f *Funnel) handleRequestButton(ctx tb.Context, btn *MessageButton) error {
+	if btn == nil {
+		return nil
+	}
+	return f.handleReplyButton(ctx, *btn)
+}
+
+func (f *Funnel) handleReplyButton(ctx tb.Context, btn MessageButton) error {
+	q, err := f.GetEventQueryHandler(btn.NextMessageID)
+	if err != nil {
+		return fmt.Errorf("get query handler: %w", err)
+	}
+
+	// reply button texts are static, btn is the script button
+	f.onButtonClick(ctx, btn.NextMessageID, btn.Text, btn.Text)
+	if btn.Param != "" {
+		ctx.Set(buttonParamContextKey, btn.Param)
+	}
+	return q.buildAndSend(ctx, UserPayload{ButtonParam: btn.Param})
+}
+
+func (q *QueryHandler) buildReplyButtons() {
+	var btns []tb.Btn
+	for _, btnData := range q.EventData.Message.Buttons {
+		switch {
+		case btnData.RequestContact:
+			btns = append(btns, q.Menu.Contact(btnData.Text))
+		case btnData.RequestLocation:
+			btns = append(btns, q.Menu.Location(btnData.Text))
+		default:
+			btns = append(btns, q.Menu.Text(btnData.Text))
+		}
+	}
+
+	q.Menu.Reply(q.layoutButtons(btns)...)
+	q.Menu.ResizeKeyboard = true
+	q.Menu.OneTimeKeyboard = q.EventData.Message.OneTimeKeyboard
+	q.Menu.IsPersistent = q.EventData.Message.PersistentKeyboard
+	q.Menu.Placeholder = q.EventData.Message.KeyboardPlaceholder
+}
+
+// returns button text with all translations
+func (btn MessageButton) getTexts() []string {
+	texts := []string{btn.Text}
+	for _, language := range sortedKeys(btn.Translations) {
+		if label := btn.Translations[language]; label != btn.Text {
+			texts = append(texts, label)
+		}
+	}
+	return texts
+}
+
+// returns button texts of reply keyboards, that lead to different events
+func (s FunnelScript) findReplyButtonConflicts() []string {
+	targets := map[string]MessageButton{}
+	conflicts := map[string]bool{}
+
+	for _, eventID := range s.eventIDs() {
+		msg := s[eventID].Message
+		if msg.Keyboard != KeyboardReply {
+			continue
+		}
+
+		for _, btn := range msg.Buttons {
+			keys := btn.getTexts()
+			switch {
+			case btn.RequestContact:
+				keys = []string{"contact request"}
+			case btn.RequestLocation:
+				keys = []string{"location request"}
+			}
+
+			for _, key := range keys {
+				target, isExists := targets[key]
+				if !isExists {
+					targets[key] = btn
+					continue
+				}
+				if target.NextMessageID != btn.NextMessageID || target.Param != btn.Param {
+					conflicts[key] = true
+				}
+			}
+		}
+	}
+
+	result := make([]string, 0, len(conflicts))
+	for key := range conflicts {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,203 @@
+package tgfun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const shutdownTimeout = 30 * time.Second
+
+type shutdownHook func(ctx context.Context) error
+
+type funnelLifecycle struct {
+	isRunning  bool
+	isStopping bool
+	hooks      []shutdownHook
+
+	inFlight int           // handlers in progress
+	idle     chan struct{} // closed when in-flight handlers are finished
+}
+
+// RunContext - run funnel and block until ctx is done, then stop it gracefully
+func (f *Funnel) RunContext(ctx context.Context) error {
+	if err := f.Run(); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return f.Stop(stopCtx)
+}
+
+// Stop polling, wait for in-flight handlers and release funnel resources.
+// ctx limits the time spent waiting for handlers
+func (f *Funnel) Stop(ctx context.Context) error {
+	f.lifecycleLocker.Lock()
+	if !f.lifecycle.isRunning || f.lifecycle.isStopping {
+		f.lifecycleLocker.Unlock()
+		return errors.New("funnel is not running")
+	}
+	f.lifecycle.isStopping = true
+	f.lifecycleLocker.Unlock()
+
+	var errs []error
+	if f.webhookServer != nil {
+		if err := f.webhookServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown webhook listener: %w", err))
+		}
+	}
+
+	f.bot.Stop()
+
+	if err := f.waitInFlight(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	// hooks are called in reverse order, like defer
+	for i := len(f.lifecycle.hooks) - 1; i >= 0; i-- {
+		if err := f.lifecycle.hooks[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	f.markStopped()
+	return errors.Join(errs...)
+}
+
+// mark funnel as running. returns error when it's already started
+func (f *Funnel) markRunning() error {
+	f.lifecycleLocker.Lock()
+	defer f.lifecycleLocker.Unlock()
+
+	if f.lifecycle.isRunning {
+		return errors.New("funnel is already running")
+	}
+	f.lifecycle.isRunning = true
+	return nil
+}
+
+func (f *Funnel) markStopped() {
+	f.lifecycleLocker.Lock()
+	defer f.lifecycleLocker.Unlock()
+
+	f.lifecycle.isRunning = false
+	f.lifecycle.isStopping = false
+	f.lifecycle.hooks = nil
+}
+
+// register resource release callback, that is called in Stop
+func (f *Funnel) addShutdownHook(hook shutdownHook) {
+	f.lifecycleLocker.Lock()
+	defer f.lifecycleLocker.Unlock()
+
+	f.lifecycle.hooks = append(f.lifecycle.hooks, hook)
+}
+
+// run task in goroutine until Stop. Task must return when ctx is done
+func (f *Funnel) startBackground(name string, task func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		task(ctx)
+	}()
+
+	f.addShutdownHook(func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return fmt.Errorf("stop %s: %w", name, stopCtx.Err())
+		}
+	})
+}
+
+// inFlightPoller - runs updates of the wrapped poller in goroutines.
+// Bot is synchronous, so handler is finished when ProcessUpdate returns,
+// and update is counted before Bot.Stop returns
+type inFlightPoller struct {
+	f      *Funnel
+	poller tb.Poller
+}
+
+func (f *Funnel) newInFlightPoller(poller tb.Poller) *inFlightPoller {
+	return &inFlightPoller{f: f, poller: poller}
+}
+
+func (p *inFlightPoller) Poll(b *tb.Bot, dest chan tb.Update, stop chan struct{}) {
+	updates := make(chan tb.Update, cap(dest))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.poller.Poll(b, updates, stop)
+	}()
+
+	for {
+		select {
+		case <-done:
+			// webhook updates are already answered, so buffered ones are handled too
+			for {
+				select {
+				case update := <-updates:
+					p.dispatch(b, update)
+				default:
+					return
+				}
+			}
+		case update := <-updates:
+			p.dispatch(b, update)
+		}
+	}
+}
+
+func (p *inFlightPoller) dispatch(b *tb.Bot, update tb.Update) {
+	p.f.handlerStarted()
+	go func() {
+		defer p.f.handlerDone()
+		b.ProcessUpdate(update)
+	}()
+}
+
+func (f *Funnel) handlerStarted() {
+	f.lifecycleLocker.Lock()
+	defer f.lifecycleLocker.Unlock()
+
+	f.lifecycle.inFlight++
+}
+
+func (f *Funnel) handlerDone() {
+	f.lifecycleLocker.Lock()
+	defer f.lifecycleLocker.Unlock()
+
+	f.lifecycle.inFlight--
+	if f.lifecycle.inFlight == 0 && f.lifecycle.idle != nil {
+		close(f.lifecycle.idle)
+		f.lifecycle.idle = nil
+	}
+}
+
+func (f *Funnel) waitInFlight(ctx context.Context) error {
+	f.lifecycleLocker.Lock()
+	if f.lifecycle.inFlight == 0 {
+		f.lifecycleLocker.Unlock()
+		return nil
+	}
+	idle := make(chan struct{})
+	f.lifecycle.idle = idle
+	f.lifecycleLocker.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for in-flight handlers: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,116 @@
+package tgfun
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestStopNotRunningFunnel(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{})
+
+	// when
+	err := f.Stop(context.Background())
+
+	// then
+	assert.Error(t, err)
+}
+
+// sends updates and waits for stop
+type staticPoller struct {
+	updates       []tb.Update
+	isStopIgnored bool // return right after updates are sent
+}
+
+func (p *staticPoller) Poll(b *tb.Bot, dest chan tb.Update, stop chan struct{}) {
+	for _, update := range p.updates {
+		dest <- update
+	}
+	if !p.isStopIgnored {
+		<-stop
+	}
+}
+
+func TestWaitInFlightHandlers(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{})
+	bot, err := tb.NewBot(tb.Settings{Offline: true, Synchronous: true})
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	bot.Handle(tb.OnText, func(tb.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	poller := f.newInFlightPoller(&staticPoller{updates: []tb.Update{
+		{Message: &tb.Message{Text: "hello", Chat: &tb.Chat{ID: 1}}},
+	}})
+	stop := make(chan struct{})
+	pollDone := make(chan struct{})
+	go func() {
+		defer close(pollDone)
+		poller.Poll(bot, make(chan tb.Update), stop)
+	}()
+	<-started
+	close(stop)
+	<-pollDone
+
+	// when
+	shortCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	errTimeout := f.waitInFlight(shortCtx)
+
+	close(release)
+	errDrained := f.waitInFlight(context.Background())
+
+	// then
+	assert.Error(t, errTimeout)
+	assert.NoError(t, errDrained)
+}
+
+func TestInFlightPollerHandlesBufferedUpdates(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{})
+	bot, err := tb.NewBot(tb.Settings{Offline: true, Synchronous: true})
+	assert.NoError(t, err)
+
+	var handled int32
+	bot.Handle(tb.OnText, func(tb.Context) error {
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+
+	var updates []tb.Update
+	for i := 0; i < 5; i++ {
+		updates = append(updates, tb.Update{
+			Message: &tb.Message{Text: "hello", Chat: &tb.Chat{ID: 1}},
+		})
+	}
+	poller := f.newInFlightPoller(&staticPoller{updates: updates, isStopIgnored: true})
+
+	// when
+	// dispatch is blocked, so updates are buffered when polling is done
+	f.lifecycleLocker.Lock()
+	pollDone := make(chan struct{})
+	go func() {
+		defer close(pollDone)
+		poller.Poll(bot, make(chan tb.Update, len(updates)), make(chan struct{}))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	f.lifecycleLocker.Unlock()
+
+	<-pollDone
+	errDrained := f.waitInFlight(context.Background())
+
+	// then
+	assert.NoError(t, errDrained)
+	assert.Equal(t, int32(len(updates)), atomic.LoadInt32(&handled))
+}
@@ -0,0 +1,202 @@
+package tgfun
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LocalizationData - multi-language funnel settings.
+// Localization is disabled when Languages are not set
+type LocalizationData struct {
+	// language of main texts, used when user language is not supported
+	DefaultLanguage string `json:"defaultLanguage"`
+	// all supported languages, including default. example: ["en", "ru"]
+	Languages []string `json:"languages"`
+}
+
+func (d LocalizationData) IsEnabled() bool {
+	return len(d.Languages) > 0
+}
+
+func (d LocalizationData) isSupported(language string) bool {
+	for _, supported := range d.Languages {
+		if supported == language {
+			return true
+		}
+	}
+	return false
+}
+
+// "en-US" -> "en"
+func normalizeLanguage(languageCode string) string {
+	language, _, _ := strings.Cut(strings.ToLower(languageCode), "-")
+	return language
+}
+
+// SetUserLanguage - save language chosen by user.
+// It has priority over telegram client language
+func (f *Funnel) SetUserLanguage(telegramUserID int64, language string) error {
+	if f.features.Users == nil {
+		return errors.New("users feature is not enabled")
+	}
+	return f.features.Users.setLanguage(telegramUserID, normalizeLanguage(language))
+}
+
+func (uft *UsersFeature) setLanguage(telegramUserID int64, language string) error {
+	user, err := uft.Store.GetUser(telegramUserID)
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	user.Language = language
+	return uft.Store.UpdateUser(*user)
+}
+
+// returns user language: chosen by user, telegram client language or default
+func (q *QueryHandler) getUserLanguage(telegramUserID int64) string {
+	localization := q.Localization
+
+	if q.Features.Users != nil {
+		user, err := q.Features.Users.Store.GetUser(telegramUserID)
+		if err != nil {
+			q.reportError(ErrorStageBuild, fmt.Errorf("get user language: %w", err))
+		}
+		if user != nil && localization.isSupported(user.Language) {
+			return user.Language
+		}
+	}
+
+	if q.sender != nil {
+		language := normalizeLanguage(q.sender.LanguageCode)
+		if localization.isSupported(language) {
+			return language
+		}
+	}
+	return localization.DefaultLanguage
+}
+
+// replace event message text, button labels and album captions
+// with user language translations
+func (q *QueryHandler) localize(telegramUserID int64) {
+	if !q.Localization.IsEnabled() {
+		return
+	}
+	msg := &q.EventData.Message
+
+	language := normalizeLanguage(msg.SetLanguage)
+	if language != "" {
+		if err := q.saveUserLanguage(telegramUserID, language); err != nil {
+			q.reportError(ErrorStageBuild, fmt.Errorf("set user language: %w", err))
+		}
+	} else {
+		language = q.getUserLanguage(telegramUserID)
+	}
+	if language == q.Localization.DefaultLanguage {
+		return
+	}
+
+	if text, isTranslated := msg.Translations[language]; isTranslated {
+		msg.Text = text
+	}
+
+	// buttons slice is shared with the script
+	buttons := make([]MessageButton, len(msg.Buttons))
+	for i, btn := range msg.Buttons {
+		if label, isTranslated := btn.Translations[language]; isTranslated {
+			btn.Text = label
+		}
+		buttons[i] = btn
+	}
+	msg.Buttons = buttons
+
+	// album slice is shared with the script too
+	if len(msg.Album) > 0 {
+		album := make([]AlbumItem, len(msg.Album))
+		for i, item := range msg.Album {
+			if caption, isTranslated := item.Translations[language]; isTranslated {
+				item.Caption = caption
+			}
+			album[i] = item
+		}
+		msg.Album = album
+	}
+}
+
+func (q *QueryHandler) saveUserLanguage(telegramUserID int64, language string) error {
+	if q.Features.Users == nil {
+		return errors.New("users feature is not enabled")
+	}
+
+	// user is created after the message is built on the first visit
+	if q.sender != nil {
+		if _, err := q.Features.Users.getUserData(q.sender, UserPayload{}); err != nil {
+			return fmt.Errorf("get user data: %w", err)
+		}
+	}
+	return q.Features.Users.setLanguage(telegramUserID, language)
+}
+
+func (d LocalizationData) validate(r *ValidationResult) {
+	if !d.IsEnabled() {
+		return
+	}
+	if !d.isSupported(d.DefaultLanguage) {
+		r.addError("", "localization.defaultLanguage", "default language %q is not in languages", d.DefaultLanguage)
+	}
+}
+
+func (f *Funnel) validateLocalization(r *ValidationResult, eventID string, msg EventMessage) {
+	localization := f.Data.Localization
+	if !localization.IsEnabled() {
+		if len(msg.Translations) > 0 || msg.SetLanguage != "" {
+			r.addWarning(eventID, "message.translations", "localization is disabled, translations are ignored")
+		}
+		return
+	}
+
+	if msg.SetLanguage != "" {
+		if !localization.isSupported(normalizeLanguage(msg.SetLanguage)) {
+			r.addError(eventID, "message.setLanguage", "language %q is not supported", msg.SetLanguage)
+		}
+		if f.features.Users == nil {
+			r.addError(eventID, "message.setLanguage", "user language choice requires users feature")
+		}
+	}
+
+	isTextStatic := msg.Text != "" && msg.Callback == nil && msg.CallbackWithParam == nil
+	for _, language := range localization.Languages {
+		if language == localization.DefaultLanguage {
+			continue
+		}
+
+		if _, isTranslated := msg.Translations[language]; isTextStatic && !isTranslated {
+			r.addWarning(eventID, "message.translations", "missing %q translation", language)
+		}
+		for i, btn := range msg.Buttons {
+			if _, isTranslated := btn.Translations[language]; !isTranslated {
+				r.addWarning(
+					eventID, fmt.Sprintf("message.buttons[%v].translations", i),
+					"missing %q translation", language,
+				)
+			}
+		}
+		for i, item := range msg.Album {
+			if _, isTranslated := item.Translations[language]; item.Caption != "" && !isTranslated {
+				r.addWarning(
+					eventID, fmt.Sprintf("message.album[%v].translations", i),
+					"missing %q translation", language,
+				)
+			}
+		}
+	}
+
+	for _, language := range sortedKeys(msg.Translations) {
+		if !localization.isSupported(language) {
+			r.addWarning(eventID, "message.translations", "language %q is not supported", language)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package tgfun
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+	tb "gopkg.in/telebot.v3"
+)
+
+func getLocalizationTestFunnel() *Funnel {
+	return NewFunnel(FunnelData{
+		Localization: LocalizationData{
+			DefaultLanguage: "en",
+			Languages:       []string{"en", "ru"},
+		},
+	}, FunnelScript{
+		"/start": {Message: EventMessage{
+			Text:         "hello",
+			Translations: map[string]string{"ru": "привет"},
+			Buttons: []MessageButton{{
+				Text:          "next",
+				NextMessageID: "russian",
+				Translations:  map[string]string{"ru": "далее"},
+			}},
+		}},
+		"russian": {Message: EventMessage{
+			Text:        "language is changed",
+			SetLanguage: "ru",
+		}},
+	})
+}
+
+func TestLocalizeByClientLanguage(t *testing.T) {
+	// given
+	f := getLocalizationTestFunnel()
+	q, err := f.GetEventQueryHandler("/start")
+	require.NoError(t, err)
+	q.sender = &tb.User{ID: 1, LanguageCode: "ru-RU"}
+
+	// when
+	q.localize(1)
+
+	// then
+	assert.Equal(t, "привет", q.EventData.Message.Text)
+	assert.Equal(t, "далее", q.EventData.Message.Buttons[0].Text)
+	assert.Equal(t, "next", f.Script["/start"].Message.Buttons[0].Text)
+}
+
+func TestLocalizeFallbackLanguage(t *testing.T) {
+	// given
+	f := getLocalizationTestFunnel()
+	q, err := f.GetEventQueryHandler("/start")
+	require.NoError(t, err)
+	q.sender = &tb.User{ID: 1, LanguageCode: "de"}
+
+	// when
+	q.localize(1)
+
+	// then
+	assert.Equal(t, "hello", q.EventData.Message.Text)
+}
+
+func TestLocalizeAlbumCaptions(t *testing.T) {
+	// given
+	f := getLocalizationTestFunnel()
+	f.Script["album"] = FunnelEvent{Message: EventMessage{
+		Album: []AlbumItem{
+			{Image: "1.jpg", Caption: "first", Translations: map[string]string{"ru": "первый"}},
+			{Image: "2.jpg", Caption: "second"},
+		},
+	}}
+	q, err := f.GetEventQueryHandler("album")
+	require.NoError(t, err)
+	q.sender = &tb.User{ID: 1, LanguageCode: "ru"}
+
+	// when
+	q.localize(1)
+
+	// then
+	assert.Equal(t, "первый", q.EventData.Message.Album[0].Caption)
+	assert.Equal(t, "second", q.EventData.Message.Album[1].Caption)
+	assert.Equal(t, "first", f.Script["album"].Message.Album[0].Caption)
+}
+
+func TestLocalizeByUserChoice(t *testing.T) {
+	// given
+	f := getLocalizationTestFunnel()
+	f.EnableUsersFeature(UsersFeature{Store: NewMemoryUserStore()})
+	sender := &tb.User{ID: 1, FirstName: "John", LanguageCode: "en"}
+
+	choice, err := f.GetEventQueryHandler("russian")
+	require.NoError(t, err)
+	choice.sender = sender
+	choice.localize(1)
+
+	q, err := f.GetEventQueryHandler("/start")
+	require.NoError(t, err)
+	q.sender = sender
+
+	// when
+	q.localize(1)
+
+	// then
+	assert.Equal(t, "привет", q.EventData.Message.Text)
+}
+
+func TestValidateMissingTranslations(t *testing.T) {
+	// given
+	f := getLocalizationTestFunnel()
+
+	// when
+	result := f.Validate()
+
+	// then
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, "message.setLanguage", result.Errors[0].Field)
+	require.Len(t, result.Warnings, 1)
+	assert.Equal(t, "russian", result.Warnings[0].EventID)
+	assert.Equal(t, "message.translations", result.Warnings[0].Field)
+}
@@ -3,6 +3,7 @@ package tgfun
 import (
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/microcosm-cc/bluemonday"
 )
@@ -13,11 +14,24 @@ func NewFunnel(data FunnelData, script FunnelScript) *Funnel {
 		Data:      data,
 		Script:    script,
 		sanitizer: bluemonday.StrictPolicy(),
+		sendLimiter: newSendLimiter(
+			time.Second/defaultBroadcastRate,
+			broadcastChatInterval,
+		),
 	}
 }
 
-// EnableUsersFeature !
+// SetResourceStore - store file IDs of uploaded files in custom storage,
+// e.g. SQL table shared by several instances of the bot
+func (f *Funnel) SetResourceStore(store ResourceStore) {
+	f.resStore = store
+}
+
+// EnableUsersFeature ! MySQL store is used when feature.Store is not set
 func (f *Funnel) EnableUsersFeature(feature UsersFeature) {
+	if feature.Store == nil {
+		feature.Store = NewMySQLUserStore(feature.DBConn, feature.TableName)
+	}
 	f.features.Users = &feature
 }
 
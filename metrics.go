@@ -0,0 +1,455 @@
+package tgfun
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	defaultMetricsNamespace = "tgfun"
+	metricsContentType      = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	defaultSendBuckets    = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	metricsNamespaceRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// MetricsFeature - funnel metrics in Prometheus text format,
+// served by Funnel.MetricsHandler
+type MetricsFeature struct {
+	// optional
+	Namespace   string    // metric names prefix. default: "tgfun"
+	SendBuckets []float64 // send latency buckets in seconds. default: 50ms-10s
+
+	metrics *funnelMetrics
+}
+
+func (f *Funnel) EnableMetricsFeature(feature MetricsFeature) error {
+	if feature.Namespace == "" {
+		feature.Namespace = defaultMetricsNamespace
+	}
+	if !metricsNamespaceRegex.MatchString(feature.Namespace) {
+		return fmt.Errorf("invalid metrics namespace %q", feature.Namespace)
+	}
+	if len(feature.SendBuckets) == 0 {
+		feature.SendBuckets = defaultSendBuckets
+	}
+	if !sort.Float64sAreSorted(feature.SendBuckets) {
+		return errors.New("metrics send buckets must be sorted")
+	}
+
+	feature.metrics = newFunnelMetrics(feature.Namespace, feature.SendBuckets)
+	f.features.Metrics = &feature
+	f.errReporter.metrics = feature.metrics
+	return nil
+}
+
+func (f *funnelFeatures) IsMetricsFeatureActive() bool {
+	return f.Metrics != nil
+}
+
+// returns nil when feature is disabled, metrics methods accept nil
+func (f *funnelFeatures) getMetrics() *funnelMetrics {
+	if !f.IsMetricsFeatureActive() {
+		return nil
+	}
+	return f.Metrics.metrics
+}
+
+// MetricsHandler - http handler for Prometheus scraper.
+// Responds with 404 when metrics feature is disabled
+func (f *Funnel) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics := f.features.getMetrics()
+		if metrics == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", metricsContentType)
+		if err := metrics.write(w); err != nil {
+			f.errReporter.report(ErrorEvent{
+				Stage: ErrorStageMetrics,
+				Err:   fmt.Errorf("write metrics: %w", err),
+			})
+		}
+	})
+}
+
+type funnelMetrics struct {
+	deliveries   *counterVec
+	buttonClicks *counterVec
+	conversions  *counterVec
+	sentMessages *counterVec
+	sendDuration *histogramVec
+	cacheLookups *counterVec
+	lockerChecks *counterVec
+	errors       *counterVec
+}
+
+func newFunnelMetrics(namespace string, sendBuckets []float64) *funnelMetrics {
+	name := func(metric string) string {
+		return namespace + "_" + metric
+	}
+
+	return &funnelMetrics{
+		deliveries: newCounterVec(
+			name("event_deliveries_total"), "Events delivered to users.", "event",
+		),
+		buttonClicks: newCounterVec(
+			name("button_clicks_total"),
+			"Button clicks by target event and script button text.",
+			"event", "button",
+		),
+		conversions: newCounterVec(
+			name("conversions_total"), "Conversions by tag.", "conversion",
+		),
+		sentMessages: newCounterVec(
+			name("messages_sent_total"), "Messages sent by message type and result.",
+			"type", "result",
+		),
+		sendDuration: newHistogramVec(
+			name("send_duration_seconds"), "Telegram send request latency by message type.",
+			sendBuckets, "type",
+		),
+		cacheLookups: newCounterVec(
+			name("resource_cache_lookups_total"), "Uploaded files cache lookups by result.",
+			"result",
+		),
+		lockerChecks: newCounterVec(
+			name("locker_checks_total"), "Subscription locker checks by result.", "result",
+		),
+		errors: newCounterVec(
+			name("errors_total"), "Errors by processing stage.", "stage",
+		),
+	}
+}
+
+func (m *funnelMetrics) onDelivered(eventID string) {
+	if m != nil {
+		m.deliveries.inc(eventID)
+	}
+}
+
+func (m *funnelMetrics) onButtonClick(eventID, buttonText string) {
+	if m != nil {
+		m.buttonClicks.inc(eventID, buttonText)
+	}
+}
+
+func (m *funnelMetrics) onConversion(conversion string) {
+	if m != nil {
+		m.conversions.inc(conversion)
+	}
+}
+
+func (m *funnelMetrics) onSend(messageType MessageType, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.sentMessages.inc(string(messageType), result)
+	m.sendDuration.observe(duration.Seconds(), string(messageType))
+}
+
+func (m *funnelMetrics) onCacheLookup(isHit bool) {
+	if m == nil {
+		return
+	}
+
+	result := "miss"
+	if isHit {
+		result = "hit"
+	}
+	m.cacheLookups.inc(result)
+}
+
+// result: passed, locked or failed
+func (m *funnelMetrics) onLockerCheck(result string) {
+	if m != nil {
+		m.lockerChecks.inc(result)
+	}
+}
+
+func (m *funnelMetrics) onError(stage ErrorStage) {
+	if m != nil {
+		m.errors.inc(string(stage))
+	}
+}
+
+func (m *funnelMetrics) write(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	m.deliveries.write(buf)
+	m.buttonClicks.write(buf)
+	m.conversions.write(buf)
+	m.sentMessages.write(buf)
+	m.sendDuration.write(buf)
+	m.cacheLookups.write(buf)
+	m.lockerChecks.write(buf)
+	m.errors.write(buf)
+	return buf.Flush()
+}
+
+// returns type of message built for sending
+func getSentMessageType(message interface{}) MessageType {
+	switch message.(type) {
+	default:
+		return MessageTypeText
+	case *tb.Photo:
+		return MessageTypePhoto
+	case *tb.Document:
+		return MessageTypeDocument
+	case *tb.Video:
+		return MessageTypeVideo
+	case *tb.Audio:
+		return MessageTypeAudio
+	case tb.Album:
+		return MessageTypeAlbum
+	case *tb.Voice:
+		return MessageTypeVoice
+	case *tb.VideoNote:
+		return MessageTypeVideoNote
+	case *tb.Animation:
+		return MessageTypeAnimation
+	case *tb.Sticker:
+		return MessageTypeSticker
+	}
+}
+
+// labeled metric series, key is label values joined with "\xff"
+type metricSeries struct {
+	locker      sync.Mutex
+	labelNames  []string
+	labelValues map[string][]string
+}
+
+func (s *metricSeries) getKey(labelValues []string) string {
+	key := strings.Join(labelValues, "\xff")
+	if _, isExists := s.labelValues[key]; !isExists {
+		s.labelValues[key] = labelValues
+	}
+	return key
+}
+
+func (s *metricSeries) sortedKeys() []string {
+	keys := make([]string, 0, len(s.labelValues))
+	for key := range s.labelValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// returns labels in exposition format, e.g. {event="offer",le="0.5"}
+func (s *metricSeries) formatLabels(key string, extra ...string) string {
+	pairs := make([]string, 0, len(s.labelNames)+1)
+	for i, value := range s.labelValues[key] {
+		pairs = append(pairs, s.labelNames[i]+`="`+escapeLabelValue(value)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+extra[i+1]+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+type counterVec struct {
+	name string
+	help string
+	metricSeries
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labelNames ...string) *counterVec {
+	return &counterVec{
+		name: name,
+		help: help,
+		metricSeries: metricSeries{
+			labelNames:  labelNames,
+			labelValues: map[string][]string{},
+		},
+		values: map[string]float64{},
+	}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	c.values[c.getKey(labelValues)]++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.formatLabels(key), formatMetricValue(c.values[key]))
+	}
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	buckets []float64 // upper bounds, +Inf is implied
+	metricSeries
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labelNames ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		metricSeries: metricSeries{
+			labelNames:  labelNames,
+			labelValues: map[string][]string{},
+		},
+		values: map[string]*histogramValue{},
+	}
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+
+	key := h.getKey(labelValues)
+	v, isExists := h.values[key]
+	if !isExists {
+		v = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		v.counts[i]++
+	}
+	v.count++
+	v.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range h.sortedKeys() {
+		v := h.values[key]
+
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += v.counts[i]
+			fmt.Fprintf(
+				w, "%s_bucket%s %v\n",
+				h.name, h.formatLabels(key, "le", formatMetricValue(upperBound)), cumulative,
+			)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %v\n", h.name, h.formatLabels(key, "le", "+Inf"), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.formatLabels(key), formatMetricValue(v.sum))
+		fmt.Fprintf(w, "%s_count%s %v\n", h.name, h.formatLabels(key), v.count)
+	}
+}
+
+// returns position and text of the clicked inline button,
+// -1 when it's not found. Position is the index in event message buttons
+func getCallbackButton(c *tb.Callback) (int, string) {
+	if c.Message == nil || c.Message.ReplyMarkup == nil {
+		return -1, ""
+	}
+
+	var index int
+	for _, row := range c.Message.ReplyMarkup.InlineKeyboard {
+		for _, btn := range row {
+			if btn.Data == c.Data {
+				return index, btn.Text
+			}
+			index++
+		}
+	}
+	return -1, ""
+}
+
+// returns script text of the clicked inline button: rendered text contains
+// template values and translations, that make metric series unbounded.
+// Source event isn't known, so it's found by button position and rendered text.
+// Empty when button is not found, e.g. after script reload
+func (f *Funnel) getScriptButtonText(eventID string, index int, renderedText string) string {
+	if index < 0 {
+		return ""
+	}
+
+	script := f.getScript()
+	for _, sourceID := range script.eventIDs() {
+		msg := script[sourceID].Message
+		if msg.Keyboard != KeyboardInline || index >= len(msg.Buttons) {
+			continue
+		}
+
+		// buttons are added to inline keyboard in script order
+		btn := msg.Buttons[index]
+		if btn.URL == "" && btn.NextMessageID == eventID && isButtonRenderedFrom(btn, renderedText) {
+			return btn.Text
+		}
+	}
+	return ""
+}
+
+func isButtonRenderedFrom(btn MessageButton, renderedText string) bool {
+	for _, text := range btn.getTexts() {
+		if !hasTemplate(text) {
+			if text == renderedText {
+				return true
+			}
+			continue
+		}
+
+		// template variables match any value
+		parts := templateVarRegexp.Split(text, -1)
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		pattern, err := regexp.Compile("^" + strings.Join(parts, "(?s:.*)") + "$")
+		if err == nil && pattern.MatchString(renderedText) {
+			return true
+		}
+	}
+	return false
+}
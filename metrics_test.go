@@ -0,0 +1,102 @@
+package tgfun
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	// given
+	m := newFunnelMetrics("bot", []float64{0.1, 1})
+	m.onButtonClick("offer", `say "hi"`)
+	m.onButtonClick("offer", `say "hi"`)
+	m.onSend(MessageTypePhoto, 50*time.Millisecond, nil)
+	m.onSend(MessageTypePhoto, 2*time.Second, nil)
+
+	// when
+	var buf bytes.Buffer
+	err := m.write(&buf)
+
+	// then
+	require.NoError(t, err)
+	output := buf.String()
+	assert.Contains(t, output, "# TYPE bot_button_clicks_total counter\n")
+	assert.Contains(t, output, `bot_button_clicks_total{event="offer",button="say \"hi\""} 2`+"\n")
+	assert.Contains(t, output, "# TYPE bot_send_duration_seconds histogram\n")
+	assert.Contains(t, output, `bot_send_duration_seconds_bucket{type="photo",le="0.1"} 1`+"\n")
+	assert.Contains(t, output, `bot_send_duration_seconds_bucket{type="photo",le="1"} 1`+"\n")
+	assert.Contains(t, output, `bot_send_duration_seconds_bucket{type="photo",le="+Inf"} 2`+"\n")
+	assert.Contains(t, output, `bot_send_duration_seconds_sum{type="photo"} 2.05`+"\n")
+	assert.Contains(t, output, `bot_send_duration_seconds_count{type="photo"} 2`+"\n")
+}
+
+func TestMetricsHandlerDisabled(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{})
+	w := httptest.NewRecorder()
+
+	// when
+	f.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// then
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestEnableMetricsFeatureInvalidBuckets(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{})
+
+	// when
+	err := f.EnableMetricsFeature(MetricsFeature{SendBuckets: []float64{1, 0.5}})
+
+	// then
+	assert.Error(t, err)
+	assert.False(t, f.features.IsMetricsFeatureActive())
+}
+
+func TestGetCallbackButton(t *testing.T) {
+	// given
+	c := &tb.Callback{
+		Data: "\foffer|",
+		Message: &tb.Message{ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: [][]tb.InlineButton{
+			{{Text: "Hi, John", Data: "\fabout|"}},
+			{{Text: "site", URL: "https://example.com"}, {Text: "Buy, John", Data: "\foffer|"}},
+		}}},
+	}
+
+	// when
+	index, text := getCallbackButton(c)
+
+	// then
+	assert.Equal(t, 2, index)
+	assert.Equal(t, "Buy, John", text)
+}
+
+func TestGetScriptButtonText(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{
+		"/start": {Message: EventMessage{Buttons: []MessageButton{
+			{Text: "Buy, {{first_name}}", NextMessageID: "offer"},
+		}}},
+		"about": {Message: EventMessage{Buttons: []MessageButton{
+			{Text: "Go to offer", NextMessageID: "offer", Translations: map[string]string{"ru": "К офферу"}},
+		}}},
+	})
+
+	// when
+	templateText := f.getScriptButtonText("offer", 0, "Buy, John")
+	translatedText := f.getScriptButtonText("offer", 0, "К офферу")
+	unknownText := f.getScriptButtonText("offer", 1, "Buy, John")
+
+	// then
+	assert.Equal(t, "Buy, {{first_name}}", templateText)
+	assert.Equal(t, "Go to offer", translatedText)
+	assert.Equal(t, "", unknownText)
+}
@@ -0,0 +1,94 @@
+package tgfun
+
+import (
+	"errors"
+	"fmt"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// NavigationMode - how event is shown on callback button click
+type NavigationMode string
+
+const (
+	NavigationDefault NavigationMode = ""     // inherit from funnel. funnel default is send
+	NavigationSend    NavigationMode = "send" // send new message
+	NavigationEdit    NavigationMode = "edit" // edit message with clicked button
+)
+
+func (m NavigationMode) isValid() bool {
+	switch m {
+	case NavigationDefault, NavigationSend, NavigationEdit:
+		return true
+	}
+	return false
+}
+
+var errNotEditable = errors.New("message type change is not editable")
+
+func (q *QueryHandler) isEditNavigation() bool {
+	mode := q.EventData.Message.Navigation
+	if mode == NavigationDefault {
+		mode = q.Navigation
+	}
+	return mode == NavigationEdit
+}
+
+// edit message with clicked button in edit navigation mode,
+// otherwise or when edit is failed send new message
+func (q *QueryHandler) editOrSend(
+	chatID int64,
+	message interface{},
+	args ...interface{},
+) (*tb.Message, error) {
+	if q.editable == nil || !q.isEditNavigation() {
+		return q.Bot.Send(tb.ChatID(chatID), message, args...)
+	}
+
+	response, err := q.edit(q.editable, message, args...)
+	switch {
+	case err == nil:
+		return response, nil
+	case errors.Is(err, tb.ErrSameMessageContent), errors.Is(err, tb.ErrMessageNotModified):
+		return q.editable, nil // the same button is clicked again
+	case !errors.Is(err, errNotEditable):
+		q.reportError(ErrorStageSend, fmt.Errorf("edit message, send new one: %w", err))
+	}
+	return q.Bot.Send(tb.ChatID(chatID), message, args...)
+}
+
+// text can't be replaced with media in telegram and vice versa
+func (q *QueryHandler) edit(
+	origin *tb.Message,
+	message interface{},
+	args ...interface{},
+) (*tb.Message, error) {
+	if q.EventData.Message.Keyboard != KeyboardInline {
+		return nil, errNotEditable // only inline keyboard can be edited
+	}
+
+	switch m := message.(type) {
+	case string:
+		if isMediaMessage(origin) {
+			return nil, errNotEditable
+		}
+		return q.Bot.Edit(origin, m, args...)
+	case tb.Inputtable:
+		if !isMediaMessage(origin) {
+			return nil, errNotEditable
+		}
+		return q.Bot.EditMedia(origin, m, args...)
+	}
+	return nil, errNotEditable
+}
+
+func isMediaMessage(m *tb.Message) bool {
+	return m.Photo != nil ||
+		m.Document != nil ||
+		m.Video != nil ||
+		m.Audio != nil ||
+		m.Animation != nil ||
+		m.Voice != nil ||
+		m.VideoNote != nil ||
+		m.Sticker != nil
+}
@@ -0,0 +1,195 @@
+package tgfun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// script file watch settings
+type scriptWatch struct {
+	path     string
+	interval time.Duration
+}
+
+// added, removed and changed events of the reloaded script
+type scriptDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+func (d scriptDiff) isEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d scriptDiff) String() string {
+	if d.isEmpty() {
+		return "no changes"
+	}
+
+	var parts []string
+	for _, group := range []struct {
+		name     string
+		eventIDs []string
+	}{
+		{"added", d.Added},
+		{"removed", d.Removed},
+		{"changed", d.Changed},
+	} {
+		if len(group.eventIDs) > 0 {
+			parts = append(parts, group.name+": "+strings.Join(group.eventIDs, ", "))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func diffScripts(oldScript, newScript FunnelScript) scriptDiff {
+	var diff scriptDiff
+	for _, eventID := range newScript.eventIDs() {
+		oldEvent, isExists := oldScript[eventID]
+		switch {
+		case !isExists:
+			diff.Added = append(diff.Added, eventID)
+		case !isEventEqual(oldEvent, newScript[eventID]):
+			diff.Changed = append(diff.Changed, eventID)
+		}
+	}
+	for _, eventID := range oldScript.eventIDs() {
+		if _, isExists := newScript[eventID]; !isExists {
+			diff.Removed = append(diff.Removed, eventID)
+		}
+	}
+	return diff
+}
+
+// callbacks can't be compared, so events are compared by script data
+func isEventEqual(a, b FunnelEvent) bool {
+	aData, errA := json.Marshal(a)
+	bData, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return reflect.DeepEqual(aData, bData)
+}
+
+func (f *Funnel) getScript() FunnelScript {
+	f.scriptLocker.RLock()
+	defer f.scriptLocker.RUnlock()
+
+	return f.Script
+}
+
+func (f *Funnel) getReplyButtons() replyButtons {
+	f.scriptLocker.RLock()
+	defer f.scriptLocker.RUnlock()
+
+	return f.replyButtons
+}
+
+// ReloadScript - validate new script and replace the current one
+// without restart. Updates in progress are finished with the old script
+func (f *Funnel) ReloadScript(script FunnelScript) error {
+	formatScript(script)
+
+	validation := f.validateScript(script)
+	for _, issue := range validation.Warnings {
+		f.errReporter.getLogger().Warn(issue.String())
+	}
+	if err := validation.Err(); err != nil {
+		return err
+	}
+
+	f.buttonParams.registerScript(script)
+	replyButtons := newReplyButtons(script)
+
+	f.scriptLocker.Lock()
+	diff := diffScripts(f.Script, script)
+	f.Script = script
+	f.replyButtons = replyButtons
+	f.scriptLocker.Unlock()
+
+	f.errReporter.getLogger().Info("funnel script reloaded", "diff", diff.String())
+	return nil
+}
+
+// WatchScriptFile - reload script when file is changed.
+// Call it before Run. Invalid script is logged and skipped
+func (f *Funnel) WatchScriptFile(path string, interval time.Duration) {
+	f.scriptWatch = &scriptWatch{path: path, interval: interval}
+}
+
+func (f *Funnel) startScriptWatch() {
+	if f.scriptWatch == nil {
+		return
+	}
+
+	watch := *f.scriptWatch
+	f.startBackground("script watch", func(ctx context.Context) {
+		f.runScriptWatch(ctx, watch)
+	})
+}
+
+func (f *Funnel) runScriptWatch(ctx context.Context, watch scriptWatch) {
+	lastInfo, err := os.Stat(watch.path)
+	if err != nil {
+		f.reportReloadError(fmt.Errorf("watch script: %w", err))
+	}
+
+	ticker := time.NewTicker(watch.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(watch.path)
+		if err != nil {
+			f.reportReloadError(fmt.Errorf("watch script: %w", err))
+			continue
+		}
+		if lastInfo != nil && info.Size() == lastInfo.Size() && info.ModTime().Equal(lastInfo.ModTime()) {
+			continue
+		}
+		lastInfo = info
+
+		if err := f.reloadScriptFile(watch.path); err != nil {
+			f.reportReloadError(fmt.Errorf("reload script: %w", err))
+		}
+	}
+}
+
+func (f *Funnel) reportReloadError(err error) {
+	f.errReporter.report(ErrorEvent{Stage: ErrorStageReload, Err: err})
+}
+
+func (f *Funnel) reloadScriptFile(path string) error {
+	script, err := LoadFunnelScript(path)
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+
+	// callbacks are set in code, so they are taken from the current script
+	current := f.getScript()
+	for eventID, event := range script {
+		if currentEvent, isExists := current[eventID]; isExists {
+			script[eventID] = copyEventCallbacks(currentEvent, event)
+		}
+	}
+	return f.ReloadScript(script)
+}
+
+func copyEventCallbacks(from, to FunnelEvent) FunnelEvent {
+	to.Message.Callback = from.Message.Callback
+	to.Message.CallbackWithParam = from.Message.CallbackWithParam
+	to.Message.OnEvent = from.Message.OnEvent
+	to.Message.OnConversion = from.Message.OnConversion
+	return to
+}
@@ -0,0 +1,86 @@
+package tgfun
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+)
+
+func TestDiffScripts(t *testing.T) {
+	// given
+	oldScript := FunnelScript{
+		"/start": {Message: EventMessage{Text: "hello"}},
+		"offer":  {Message: EventMessage{Text: "offer"}},
+		"old":    {Message: EventMessage{Text: "old"}},
+	}
+	newScript := FunnelScript{
+		"/start": {Message: EventMessage{Text: "hello"}},
+		"offer":  {Message: EventMessage{Text: "new offer"}},
+		"new":    {Message: EventMessage{Text: "new"}},
+	}
+
+	// when
+	diff := diffScripts(oldScript, newScript)
+
+	// then
+	assert.Equal(t, []string{"new"}, diff.Added)
+	assert.Equal(t, []string{"old"}, diff.Removed)
+	assert.Equal(t, []string{"offer"}, diff.Changed)
+	assert.Equal(t, "added: new; removed: old; changed: offer", diff.String())
+}
+
+func TestReloadInvalidScript(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{
+		"/start": {Message: EventMessage{Text: "hello"}},
+	})
+
+	// when
+	err := f.ReloadScript(FunnelScript{
+		"/start": {Message: EventMessage{
+			Text:    "hello",
+			Buttons: []MessageButton{{Text: "next", NextMessageID: "missing"}},
+		}},
+	})
+
+	// then
+	assert.Error(t, err)
+	assert.Equal(t, "hello", f.getScript()["/start"].Message.Text)
+	assert.Empty(t, f.getScript()["/start"].Message.Buttons)
+}
+
+func TestScriptWatchReportsReloadError(t *testing.T) {
+	// given
+	scriptPath := filepath.Join(t.TempDir(), "script.json")
+	require.NoError(t, os.WriteFile(scriptPath, []byte("{}"), 0o644))
+
+	f := NewFunnel(FunnelData{}, FunnelScript{})
+	errorEvents := make(chan ErrorEvent, 1)
+	f.OnError(func(e ErrorEvent) {
+		select {
+		case errorEvents <- e:
+		default:
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.runScriptWatch(ctx, scriptWatch{path: scriptPath, interval: 5 * time.Millisecond})
+
+	// when
+	time.Sleep(20 * time.Millisecond) // watch reads initial file info
+	require.NoError(t, os.WriteFile(scriptPath, []byte("not a script"), 0o644))
+
+	// then
+	select {
+	case e := <-errorEvents:
+		assert.Equal(t, ErrorStageReload, e.Stage)
+	case <-time.After(time.Second):
+		t.Fatal("reload error is not reported")
+	}
+}
@@ -0,0 +1,347 @@
+package tgfun
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	resourceFileRefreshInterval = 10 * time.Second
+	resourceFileMaxOutdated     = 1000 // outdated records, that trigger file rewrite
+)
+
+// ResourceStore - uploaded files cache storage backend.
+// File and SQL stores can be shared by several instances of the same bot,
+// so a file uploaded by one instance is reused by others
+type ResourceStore interface {
+	// returns nil when resource not found
+	GetResource(localFilePath string) (*Resource, error)
+	SaveResource(localFilePath string, res Resource) error
+}
+
+// MemoryResourceStore - in-memory resources storage, lost on restart
+type MemoryResourceStore struct {
+	data sync.Map // local file path -> Resource
+}
+
+func NewMemoryResourceStore() *MemoryResourceStore {
+	return &MemoryResourceStore{}
+}
+
+func (s *MemoryResourceStore) GetResource(localFilePath string) (*Resource, error) {
+	res, isExists := s.data.Load(localFilePath)
+	if !isExists {
+		return nil, nil
+	}
+
+	resData := res.(Resource)
+	return &resData, nil
+}
+
+func (s *MemoryResourceStore) SaveResource(localFilePath string, res Resource) error {
+	s.data.Store(localFilePath, res)
+	return nil
+}
+
+// FileResourceStore - resources storage in JSON lines file.
+// Updates are appended to the file, and records appended by other processes
+// are read every 10 seconds or when resource is not found.
+// Outdated records are removed on load and when there are too many of them
+type FileResourceStore struct {
+	path            string
+	refreshInterval time.Duration
+
+	locker      sync.Mutex
+	data        map[string]Resource
+	offset      int64       // size of the file part already read
+	records     int         // records read and written, including outdated
+	fileInfo    os.FileInfo // file read last time, to detect replaced file
+	refreshedAt time.Time
+}
+
+// one line of the resources file
+type resourceRecord struct {
+	Path string `json:"path"`
+	Resource
+}
+
+// NewFileResourceStore opens resources file.
+// Cache file of the previous format, single JSON object, is converted
+func NewFileResourceStore(filePath string) (*FileResourceStore, error) {
+	s := &FileResourceStore{
+		path:            filePath,
+		refreshInterval: resourceFileRefreshInterval,
+		data:            map[string]Resource{},
+	}
+
+	if err := s.convertLegacyFile(); err != nil {
+		return nil, fmt.Errorf("convert %q: %w", filePath, err)
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if err := s.refresh(); err != nil {
+		return nil, fmt.Errorf("load %q: %w", filePath, err)
+	}
+	if err := s.compact(); err != nil {
+		return nil, fmt.Errorf("compact %q: %w", filePath, err)
+	}
+	return s, nil
+}
+
+func (s *FileResourceStore) convertLegacyFile() error {
+	fileBytes, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	legacyData := map[string]Resource{}
+	if err := json.Unmarshal(fileBytes, &legacyData); err != nil {
+		return nil // not a legacy file
+	}
+
+	return writeResourcesFile(s.path, legacyData)
+}
+
+// replace file with one record per resource
+func writeResourcesFile(path string, data map[string]Resource) error {
+	var buf bytes.Buffer
+	for _, localFilePath := range sortedResourceKeys(data) {
+		if err := writeResourceRecord(&buf, localFilePath, data[localFilePath]); err != nil {
+			return err
+		}
+	}
+
+	// rename is atomic, so other processes don't read half-written file
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// rewrite file without outdated records. must be called under lock.
+// Records appended by other processes during rewrite are lost,
+// so their files are uploaded again
+func (s *FileResourceStore) compact() error {
+	if s.records <= len(s.data) {
+		return nil
+	}
+
+	if err := writeResourcesFile(s.path, s.data); err != nil {
+		return err
+	}
+	s.fileInfo = nil
+	return s.refresh() // read rewritten file from the start
+}
+
+func sortedResourceKeys(m map[string]Resource) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeResourceRecord(w io.Writer, localFilePath string, res Resource) error {
+	line, err := json.Marshal(resourceRecord{Path: localFilePath, Resource: res})
+	if err != nil {
+		return fmt.Errorf("encode resource: %w", err)
+	}
+
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// read records appended since the last read. must be called under lock
+func (s *FileResourceStore) refresh() error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	s.refreshedAt = time.Now()
+	if s.fileInfo == nil || !os.SameFile(info, s.fileInfo) || info.Size() < s.offset {
+		// file is replaced, e.g. compacted, read it again
+		s.offset = 0
+		s.records = 0
+		s.data = map[string]Resource{}
+	}
+	s.fileInfo = info
+	if info.Size() == s.offset {
+		return nil
+	}
+
+	if _, err := file.Seek(s.offset, io.SeekStart); err != nil {
+		return err
+	}
+	newBytes, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	// the last line can be written right now by another process
+	completeLen := bytes.LastIndexByte(newBytes, '\n') + 1
+	for _, line := range bytes.Split(newBytes[:completeLen], []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record resourceRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("decode resource: %w", err)
+		}
+		s.data[record.Path] = record.Resource
+		s.records++
+	}
+
+	s.offset += int64(completeLen)
+	return nil
+}
+
+// file is read every refresh interval, and on miss: resource is uploaded
+// after miss, that is much slower than file read
+func (s *FileResourceStore) GetResource(localFilePath string) (*Resource, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	res, isExists := s.data[localFilePath]
+	if isExists && time.Since(s.refreshedAt) < s.refreshInterval {
+		return &res, nil
+	}
+
+	if err := s.refresh(); err != nil {
+		return nil, fmt.Errorf("read %q: %w", s.path, err)
+	}
+
+	res, isExists = s.data[localFilePath]
+	if !isExists {
+		return nil, nil
+	}
+	return &res, nil
+}
+
+func (s *FileResourceStore) SaveResource(localFilePath string, res Resource) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	// one write call with O_APPEND keeps lines of several processes whole
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %q: %w", s.path, err)
+	}
+	defer file.Close()
+
+	if err := writeResourceRecord(file, localFilePath, res); err != nil {
+		return fmt.Errorf("write %q: %w", s.path, err)
+	}
+
+	// written record is read with records of other processes
+	if err := s.refresh(); err != nil {
+		return fmt.Errorf("read %q: %w", s.path, err)
+	}
+	if s.records-len(s.data) > resourceFileMaxOutdated {
+		if err := s.compact(); err != nil {
+			return fmt.Errorf("compact %q: %w", s.path, err)
+		}
+	}
+	return nil
+}
+
+// SQLResourceStore - resources storage in MySQL, PostgreSQL or SQLite table.
+// Table schemas can be found in features/resources*.sql
+type SQLResourceStore struct {
+	db      *sql.DB
+	table   string // quoted
+	dialect sqlDialect
+}
+
+func NewMySQLResourceStore(db *sql.DB, tableName string) *SQLResourceStore {
+	return newSQLResourceStore(db, tableName, sqlDialectMySQL)
+}
+
+func NewPostgresResourceStore(db *sql.DB, tableName string) *SQLResourceStore {
+	return newSQLResourceStore(db, tableName, sqlDialectPostgres)
+}
+
+func NewSQLiteResourceStore(db *sql.DB, tableName string) *SQLResourceStore {
+	return newSQLResourceStore(db, tableName, sqlDialectSQLite)
+}
+
+func newSQLResourceStore(db *sql.DB, tableName string, dialect sqlDialect) *SQLResourceStore {
+	return &SQLResourceStore{
+		db:      db,
+		table:   dialect.quoteIdent(tableName),
+		dialect: dialect,
+	}
+}
+
+// returns nil when resource not found
+func (s *SQLResourceStore) GetResource(localFilePath string) (*Resource, error) {
+	sqlQuery := s.dialect.rebind(
+		"SELECT file_id,file_unique_id,size,hash,expire_at FROM " + s.table +
+			" WHERE path=? LIMIT 1",
+	)
+
+	var res Resource
+	var expireAt int64
+	err := s.db.QueryRow(sqlQuery, localFilePath).Scan(
+		&res.FileID,
+		&res.FileUniqueID,
+		&res.Size,
+		&res.Hash,
+		&expireAt,
+	)
+	if err != nil {
+		if isSQLErrNoRows(err) {
+			return nil, nil
+		}
+		return nil, errors.New("failed to select resource: " + err.Error())
+	}
+
+	res.ExpireAt = fromUnixTimestamp(expireAt)
+	return &res, nil
+}
+
+func (s *SQLResourceStore) SaveResource(localFilePath string, res Resource) error {
+	sqlQuery := s.dialect.rebind(
+		"INSERT INTO " + s.table + " (path,file_id,file_unique_id,size,hash,expire_at)" +
+			" VALUES (?,?,?,?,?,?)" +
+			s.dialect.upsert("path", "file_id", "file_unique_id", "size", "hash", "expire_at"),
+	)
+	_, err := s.db.Exec(
+		sqlQuery,
+		localFilePath,
+		res.FileID,
+		res.FileUniqueID,
+		res.Size,
+		res.Hash,
+		toUnixTimestamp(res.ExpireAt),
+	)
+	if err != nil {
+		return errors.New("failed to save resource: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package tgfun
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestFileResourceStoreSharedByInstances(t *testing.T) {
+	// given
+	cachePath := filepath.Join(t.TempDir(), "cache.jsonl")
+	first, errFirst := NewFileResourceStore(cachePath)
+	require.NoError(t, errFirst)
+	second, errSecond := NewFileResourceStore(cachePath)
+	require.NoError(t, errSecond)
+
+	// when
+	require.NoError(t, first.SaveResource("intro.mp4", Resource{FileID: "video-1"}))
+	require.NoError(t, first.SaveResource("intro.mp4", Resource{FileID: "video-2"}))
+	res, err := second.GetResource("intro.mp4")
+	missing, errMissing := second.GetResource("missing.mp4")
+
+	// then
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, "video-2", res.FileID)
+	assert.NoError(t, errMissing)
+	assert.Nil(t, missing)
+}
+
+func TestFileResourceStoreConvertsLegacyFile(t *testing.T) {
+	// given
+	cachePath := filepath.Join(t.TempDir(), "cache.json")
+	legacy := `{"logo.png": {"fileID": "photo-1", "size": 10, "hash": "abc"}}`
+	require.NoError(t, os.WriteFile(cachePath, []byte(legacy), 0o644))
+
+	// when
+	store, err := NewFileResourceStore(cachePath)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveResource("intro.mp4", Resource{FileID: "video-1"}))
+
+	reopened, errReopen := NewFileResourceStore(cachePath)
+	require.NoError(t, errReopen)
+	logo, errLogo := reopened.GetResource("logo.png")
+	intro, errIntro := reopened.GetResource("intro.mp4")
+
+	// then
+	require.NoError(t, errLogo)
+	require.NoError(t, errIntro)
+	require.NotNil(t, logo)
+	assert.Equal(t, "photo-1", logo.FileID)
+	assert.Equal(t, int64(10), logo.Size)
+	require.NotNil(t, intro)
+	assert.Equal(t, "video-1", intro.FileID)
+}
+
+func TestFileResourceStoreCompactsOnLoad(t *testing.T) {
+	// given
+	cachePath := filepath.Join(t.TempDir(), "cache.jsonl")
+	store, err := NewFileResourceStore(cachePath)
+	require.NoError(t, err)
+	for _, fileID := range []string{"video-1", "video-2", "video-3"} {
+		require.NoError(t, store.SaveResource("intro.mp4", Resource{FileID: fileID}))
+	}
+	require.NoError(t, store.SaveResource("logo.png", Resource{FileID: "photo-1"}))
+
+	// when
+	reopened, err := NewFileResourceStore(cachePath)
+	require.NoError(t, err)
+	intro, errIntro := reopened.GetResource("intro.mp4")
+
+	// then
+	require.NoError(t, errIntro)
+	require.NotNil(t, intro)
+	assert.Equal(t, "video-3", intro.FileID)
+
+	fileBytes, err := os.ReadFile(cachePath)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(fileBytes), "\n"))
+}
+
+func TestFileResourceStoreRefreshInterval(t *testing.T) {
+	// given
+	cachePath := filepath.Join(t.TempDir(), "cache.jsonl")
+	first, err := NewFileResourceStore(cachePath)
+	require.NoError(t, err)
+	require.NoError(t, first.SaveResource("intro.mp4", Resource{FileID: "video-1"}))
+	second, err := NewFileResourceStore(cachePath)
+	require.NoError(t, err)
+
+	// when
+	require.NoError(t, first.SaveResource("intro.mp4", Resource{FileID: "video-2"}))
+	cached, errCached := second.GetResource("intro.mp4")
+	second.refreshInterval = 0
+	refreshed, errRefreshed := second.GetResource("intro.mp4")
+
+	// then
+	require.NoError(t, errCached)
+	require.NoError(t, errRefreshed)
+	assert.Equal(t, "video-1", cached.FileID) // file is not read on every lookup
+	assert.Equal(t, "video-2", refreshed.FileID)
+}
+
+func TestResourcesCacheSharedStore(t *testing.T) {
+	// given
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "logo.png"), []byte("logo"), 0o644))
+	store := NewMemoryResourceStore()
+	first := NewResourceCacheWithStore(store, root)
+	second := NewResourceCacheWithStore(store, root)
+
+	// when
+	uploaded := first.Get("logo.png")
+	first.Actualize("logo.png", tb.File{FileID: "photo-1", UniqueID: "unique-1"})
+	cached := second.Get("logo.png")
+
+	// then
+	assert.True(t, uploaded.OnDisk())
+	assert.Equal(t, "photo-1", cached.FileID)
+	assert.False(t, second.IsNeedUpdate("logo.png", cached))
+
+	res, err := store.GetResource("logo.png")
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.NotEmpty(t, res.Hash)
+	assert.True(t, res.ExpireAt.After(time.Now()))
+}
+
+func TestResourcesCacheChangedFileIsUploaded(t *testing.T) {
+	// given
+	root := t.TempDir()
+	filePath := filepath.Join(root, "logo.png")
+	require.NoError(t, os.WriteFile(filePath, []byte("logo"), 0o644))
+	cache := NewResourceCacheWithStore(NewMemoryResourceStore(), root)
+	cache.Actualize("logo.png", tb.File{FileID: "photo-1"})
+
+	// when
+	require.NoError(t, os.WriteFile(filePath, []byte("new logo"), 0o644))
+	changed := cache.Get("logo.png")
+
+	// then
+	assert.True(t, changed.OnDisk())
+	assert.True(t, cache.IsNeedUpdate("logo.png", changed))
+}
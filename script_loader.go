@@ -0,0 +1,314 @@
+package tgfun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScriptError - funnel script problem with position in the source file
+type ScriptError struct {
+	File    string
+	Line    int
+	Column  int
+	Path    string // field path, example: "/start.message.buttons[0].nextID"
+	Message string
+}
+
+func (e ScriptError) Error() string {
+	var pos string
+	if e.File != "" {
+		pos = e.File + ":"
+	}
+	if e.Line > 0 {
+		pos += fmt.Sprintf("%v:%v:", e.Line, e.Column)
+	}
+
+	if e.Path == "" {
+		return strings.TrimSpace(pos + " " + e.Message)
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s: %s", pos, e.Path, e.Message))
+}
+
+// ScriptErrors - all problems found in funnel script file
+type ScriptErrors []ScriptError
+
+func (e ScriptErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, scriptErr := range e {
+		lines = append(lines, scriptErr.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LoadFunnelScript - read funnel scenario from JSON or YAML file.
+// All schema problems are returned at once as ScriptErrors
+func LoadFunnelScript(path string) (FunnelScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read script: %w", err)
+	}
+
+	return ParseFunnelScript(filepath.Base(path), data)
+}
+
+// ParseFunnelScript - parse funnel scenario from JSON or YAML data.
+// name is used in error messages only
+func ParseFunnelScript(name string, data []byte) (FunnelScript, error) {
+	// YAML is a superset of JSON, so one parser covers both formats
+	// and gives us line numbers for every node
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, ScriptErrors{newScriptSyntaxError(name, err)}
+	}
+
+	if root.Kind == 0 || len(root.Content) == 0 {
+		return nil, ScriptErrors{{File: name, Message: "script is empty"}}
+	}
+
+	v := scriptValidator{file: name}
+	v.validateNode(root.Content[0], reflect.TypeOf(FunnelScript{}), "")
+	if len(v.errors) > 0 {
+		return nil, v.errors
+	}
+
+	// decode through JSON to reuse json tags of the script structs
+	var raw interface{}
+	if err := root.Content[0].Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decode script: %w", err)
+	}
+
+	jsonData, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("encode script: %w", err)
+	}
+
+	script := FunnelScript{}
+	if err := json.Unmarshal(jsonData, &script); err != nil {
+		return nil, fmt.Errorf("decode script: %w", err)
+	}
+	return script, nil
+}
+
+func newScriptSyntaxError(file string, err error) ScriptError {
+	scriptErr := ScriptError{File: file, Message: err.Error()}
+
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		scriptErr.Message = typeErr.Errors[0]
+	}
+
+	// yaml errors look like "yaml: line 3: did not find expected key"
+	msg := strings.TrimPrefix(scriptErr.Message, "yaml: ")
+	if strings.HasPrefix(msg, "line ") {
+		parts := strings.SplitN(strings.TrimPrefix(msg, "line "), ":", 2)
+		if line, err := strconv.Atoi(parts[0]); err == nil && len(parts) == 2 {
+			scriptErr.Line = line
+			msg = strings.TrimSpace(parts[1])
+		}
+	}
+	scriptErr.Message = msg
+	return scriptErr
+}
+
+type scriptValidator struct {
+	file   string
+	errors ScriptErrors
+}
+
+func (v *scriptValidator) addError(node *yaml.Node, path string, format string, args ...interface{}) {
+	v.errors = append(v.errors, ScriptError{
+		File:    v.file,
+		Line:    node.Line,
+		Column:  node.Column,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *scriptValidator) validateNode(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return // zero value
+	}
+
+	switch t.Kind() {
+	default:
+		v.addError(node, path, "unsupported field type %s", t)
+	case reflect.Map:
+		v.validateMap(node, t, path)
+	case reflect.Struct:
+		v.validateStruct(node, t, path)
+	case reflect.Slice:
+		v.validateSlice(node, t, path)
+	case reflect.String:
+		v.validateScalar(node, path, "!!str", "string")
+		switch t {
+		case reflect.TypeOf(ParseFormat("")):
+			v.validateParseFormat(node, path)
+		case reflect.TypeOf(NavigationMode("")):
+			v.validateNavigationMode(node, path)
+		case reflect.TypeOf(KeyboardKind("")):
+			v.validateKeyboardKind(node, path)
+		}
+	case reflect.Bool:
+		v.validateScalar(node, path, "!!bool", "boolean")
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		v.validateScalar(node, path, "!!int", "integer")
+	}
+}
+
+func (v *scriptValidator) validateScalar(node *yaml.Node, path, tag, typeName string) {
+	if node.Kind != yaml.ScalarNode || node.Tag != tag {
+		v.addError(node, path, "expected %s, got %s", typeName, describeNode(node))
+	}
+}
+
+func (v *scriptValidator) validateParseFormat(node *yaml.Node, path string) {
+	switch ParseFormat(node.Value) {
+	case "", ParseFormatMarkdown, ParseFormatHTML:
+		return
+	}
+	v.addError(
+		node, path, "unknown format %q, expected %q or %q",
+		node.Value, ParseFormatMarkdown, ParseFormatHTML,
+	)
+}
+
+func (v *scriptValidator) validateNavigationMode(node *yaml.Node, path string) {
+	if NavigationMode(node.Value).isValid() {
+		return
+	}
+	v.addError(
+		node, path, "unknown navigation mode %q, expected %q or %q",
+		node.Value, NavigationSend, NavigationEdit,
+	)
+}
+
+func (v *scriptValidator) validateKeyboardKind(node *yaml.Node, path string) {
+	if KeyboardKind(node.Value).isValid() {
+		return
+	}
+	v.addError(
+		node, path, "unknown keyboard %q, expected %q or %q",
+		node.Value, KeyboardReply, KeyboardRemove,
+	)
+}
+
+func (v *scriptValidator) validateMap(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind != yaml.MappingNode {
+		v.addError(node, path, "expected object, got %s", describeNode(node))
+		return
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		keyPath := joinScriptPath(path, keyNode.Value)
+
+		if seen[keyNode.Value] {
+			v.addError(keyNode, keyPath, "duplicate key %q", keyNode.Value)
+			continue
+		}
+		seen[keyNode.Value] = true
+
+		v.validateNode(valueNode, t.Elem(), keyPath)
+	}
+}
+
+func (v *scriptValidator) validateStruct(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind != yaml.MappingNode {
+		v.addError(node, path, "expected object, got %s", describeNode(node))
+		return
+	}
+
+	fields := getJSONFields(t)
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		keyPath := joinScriptPath(path, keyNode.Value)
+
+		field, isKnown := fields[keyNode.Value]
+		if !isKnown {
+			v.addError(keyNode, keyPath, "unknown field %q", keyNode.Value)
+			continue
+		}
+		if seen[keyNode.Value] {
+			v.addError(keyNode, keyPath, "duplicate key %q", keyNode.Value)
+			continue
+		}
+		seen[keyNode.Value] = true
+
+		v.validateNode(valueNode, field.Type, keyPath)
+	}
+}
+
+func (v *scriptValidator) validateSlice(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind != yaml.SequenceNode {
+		v.addError(node, path, "expected list, got %s", describeNode(node))
+		return
+	}
+
+	for i, itemNode := range node.Content {
+		v.validateNode(itemNode, t.Elem(), fmt.Sprintf("%s[%v]", path, i))
+	}
+}
+
+// returns json tag name -> field
+func getJSONFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func joinScriptPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	default:
+		return "unknown value"
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "list"
+	case yaml.ScalarNode:
+		switch node.Tag {
+		default:
+			return fmt.Sprintf("%q", node.Value)
+		case "!!str":
+			return fmt.Sprintf("string %q", node.Value)
+		case "!!int", "!!float":
+			return "number " + node.Value
+		case "!!bool":
+			return "boolean " + node.Value
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package tgfun
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+)
+
+func TestLoadFunnelScriptJSON(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "script.json")
+	data := "{\n\t\"/start\": {\n\t\t\"message\": {\n\t\t\t\"text\": \"hello\",\n" +
+		"\t\t\t\"buttons\": [{\"text\": \"next\", \"nextID\": \"offer\"}]\n\t\t}\n\t},\n" +
+		"\t\"offer\": {\"message\": {\"text\": \"offer\", \"format\": \"HTML\"}}\n}\n"
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+	// when
+	script, err := LoadFunnelScript(path)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "hello", script["/start"].Message.Text)
+	assert.Equal(t, "offer", script["/start"].Message.Buttons[0].NextMessageID)
+	assert.Equal(t, ParseFormatHTML, script["offer"].Message.Format)
+}
+
+func TestLoadFunnelScriptYAML(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "script.yaml")
+	data := `
+/start:
+  message:
+    text: hello
+    pin: true
+    video:
+      path: intro.mp4
+      width: 640
+  locker:
+    enabled: true
+    chatID: -100123
+    lockerMessageID: subscribe
+`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+	// when
+	script, err := LoadFunnelScript(path)
+
+	// then
+	require.NoError(t, err)
+	assert.True(t, script["/start"].Message.PinThisMessage)
+	assert.Equal(t, 640, script["/start"].Message.Video.Width)
+	assert.Equal(t, int64(-100123), script["/start"].SubscriptionLocker.ChatID)
+}
+
+func TestLoadFunnelScriptReportsAllErrors(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "script.yaml")
+	data := `
+/start:
+  message:
+    txt: hello
+    pin: yes please
+    format: markdown2
+    buttons:
+      - text: 1
+`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+	// when
+	_, err := LoadFunnelScript(path)
+
+	// then
+	var scriptErrs ScriptErrors
+	require.True(t, errors.As(err, &scriptErrs))
+	require.Len(t, scriptErrs, 4)
+	assert.Equal(t, "/start.message.txt", scriptErrs[0].Path)
+	assert.Equal(t, 4, scriptErrs[0].Line)
+	assert.Equal(t, "/start.message.pin", scriptErrs[1].Path)
+	assert.Equal(t, "/start.message.format", scriptErrs[2].Path)
+	assert.Equal(t, "/start.message.buttons[0].text", scriptErrs[3].Path)
+	assert.Contains(t, err.Error(), "script.yaml:4:5:")
+}
+
+func TestLoadFunnelScriptSyntaxError(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "script.json")
+	data := "{\n  \"/start\": {\"message\": {\"text\": \"hello\"}\n"
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+	// when
+	_, err := LoadFunnelScript(path)
+
+	// then
+	var scriptErrs ScriptErrors
+	require.True(t, errors.As(err, &scriptErrs))
+	assert.Equal(t, "script.json", scriptErrs[0].File)
+	assert.NotZero(t, scriptErrs[0].Line)
+}
@@ -0,0 +1,308 @@
+package tgfun
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	defaultSessionMaxVisits = 100
+	sessionContextKey       = "tgfun.session"
+)
+
+// SessionVisit - event delivered to user
+type SessionVisit struct {
+	EventID string    `json:"event"`
+	At      time.Time `json:"at"`
+}
+
+// SessionData - stored user progress in funnel
+type SessionData struct {
+	TelegramUserID int64             `json:"tid"`
+	CurrentEventID string            `json:"current"`
+	Visits         []SessionVisit    `json:"visits"` // oldest first
+	Variables      map[string]string `json:"vars"`
+	UpdatedAt      time.Time         `json:"updatedAt"`
+}
+
+// Session - user progress in funnel, safe for concurrent use
+type Session struct {
+	locker sync.RWMutex
+	data   SessionData
+}
+
+func newSession(data SessionData) *Session {
+	if data.Variables == nil {
+		data.Variables = map[string]string{}
+	}
+	return &Session{data: data}
+}
+
+func (s *Session) TelegramUserID() int64 {
+	return s.data.TelegramUserID
+}
+
+// CurrentEventID returns the last event sent to user
+func (s *Session) CurrentEventID() string {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	return s.data.CurrentEventID
+}
+
+// Visits returns delivered events, oldest first
+func (s *Session) Visits() []SessionVisit {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	return append([]SessionVisit{}, s.data.Visits...)
+}
+
+// LastVisit returns the time event was delivered last time
+func (s *Session) LastVisit(eventID string) (time.Time, bool) {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	for i := len(s.data.Visits) - 1; i >= 0; i-- {
+		if s.data.Visits[i].EventID == eventID {
+			return s.data.Visits[i].At, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (s *Session) IsVisited(eventID string) bool {
+	_, isVisited := s.LastVisit(eventID)
+	return isVisited
+}
+
+// Get session variable. returns empty string when not set
+func (s *Session) Get(key string) string {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	return s.data.Variables[key]
+}
+
+// Set session variable. It is saved after the current event is handled
+// or with Funnel.SaveUserSession
+func (s *Session) Set(key, value string) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.data.Variables[key] = value
+}
+
+func (s *Session) Delete(key string) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	delete(s.data.Variables, key)
+}
+
+// Data returns session snapshot
+func (s *Session) Data() SessionData {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	data := s.data
+	data.Visits = append([]SessionVisit{}, s.data.Visits...)
+	data.Variables = make(map[string]string, len(s.data.Variables))
+	for k, v := range s.data.Variables {
+		data.Variables[k] = v
+	}
+	return data
+}
+
+func (s *Session) visit(eventID string, maxVisits int) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	now := time.Now()
+	s.data.CurrentEventID = eventID
+	s.data.Visits = append(s.data.Visits, SessionVisit{EventID: eventID, At: now})
+	if len(s.data.Visits) > maxVisits {
+		s.data.Visits = s.data.Visits[len(s.data.Visits)-maxVisits:]
+	}
+	s.data.UpdatedAt = now
+}
+
+// SessionsFeature - feature to track user progress in funnel
+type SessionsFeature struct {
+	// required
+	Store SessionStore
+
+	// optional
+	MaxVisits int // visits history limit. default: 100
+
+	state *sessionsState
+}
+
+type sessionsState struct {
+	locks  userLocks
+	active sync.Map // telegram user ID -> *Session, while event is handled
+}
+
+func (f *Funnel) EnableSessionsFeature(feature SessionsFeature) error {
+	if feature.Store == nil {
+		return errors.New("session store is not set")
+	}
+	if feature.MaxVisits <= 0 {
+		feature.MaxVisits = defaultSessionMaxVisits
+	}
+
+	feature.state = &sessionsState{}
+	f.features.Sessions = &feature
+	return nil
+}
+
+func (f *funnelFeatures) IsSessionsFeatureActive() bool {
+	return f.Sessions != nil
+}
+
+// SessionFromContext returns user session in OnEvent callback.
+// Returns nil when sessions feature is disabled
+func SessionFromContext(ctx tb.Context) *Session {
+	session, _ := ctx.Get(sessionContextKey).(*Session)
+	return session
+}
+
+// GetUserSession - get user session, e.g. in Callback or OnConversion.
+// During event handling the same session is returned, that will be saved
+// after the event is sent
+func (f *Funnel) GetUserSession(telegramUserID int64) (*Session, error) {
+	if !f.features.IsSessionsFeatureActive() {
+		return nil, errors.New("sessions feature is not enabled")
+	}
+	return f.features.Sessions.get(telegramUserID)
+}
+
+// WithSession - send event in the session of the event being handled.
+// Use it to send events of the same user from OnEvent, Callback or OnConversion,
+// e.g. q.WithSession(tgfun.SessionFromContext(ctx)).CustomHandle(userID):
+// the user is locked until the parent event is handled
+func (q *QueryHandler) WithSession(session *Session) *QueryHandler {
+	q.session = session
+	return q
+}
+
+// SaveUserSession - save session changed out of event handling
+func (f *Funnel) SaveUserSession(session *Session) error {
+	if !f.features.IsSessionsFeatureActive() {
+		return errors.New("sessions feature is not enabled")
+	}
+	return f.features.Sessions.Store.SaveSession(session.Data())
+}
+
+func (sf *SessionsFeature) get(telegramUserID int64) (*Session, error) {
+	if session, isActive := sf.state.active.Load(telegramUserID); isActive {
+		return session.(*Session), nil
+	}
+
+	data, err := sf.Store.GetSession(telegramUserID)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return newSession(SessionData{TelegramUserID: telegramUserID}), nil
+	}
+	return newSession(*data), nil
+}
+
+// run send in user session and save the visit of event on success.
+// session is nil when feature is disabled
+func (q *QueryHandler) inSession(
+	telegramUserID int64,
+	send func(session *Session) error,
+) error {
+	if !q.Features.IsSessionsFeatureActive() {
+		return send(nil)
+	}
+	sf := q.Features.Sessions
+
+	// nested event of the same user shares the parent session,
+	// user lock is already held by the parent
+	if q.session != nil && q.session.TelegramUserID() == telegramUserID {
+		return q.sendInSession(sf, q.session, send)
+	}
+
+	unlock := sf.state.locks.lock(telegramUserID)
+	defer unlock()
+
+	session, err := sf.get(telegramUserID)
+	if err != nil {
+		q.reportError(ErrorStageSession, fmt.Errorf("load: %w", err))
+		session = newSession(SessionData{TelegramUserID: telegramUserID})
+	}
+
+	sf.state.active.Store(telegramUserID, session)
+	defer sf.state.active.Delete(telegramUserID)
+
+	return q.sendInSession(sf, session, send)
+}
+
+// send and save the visit of event on success
+func (q *QueryHandler) sendInSession(
+	sf *SessionsFeature,
+	session *Session,
+	send func(session *Session) error,
+) error {
+	q.session = session
+	if err := send(session); err != nil {
+		return err
+	}
+
+	eventID, _ := q.getDeliveredEvent()
+	if eventID == "" {
+		return nil // event out of script, e.g. broadcast text
+	}
+
+	session.visit(eventID, sf.MaxVisits)
+	if err := sf.Store.SaveSession(session.Data()); err != nil {
+		q.reportError(ErrorStageSession, fmt.Errorf("save: %w", err))
+	}
+	return nil
+}
+
+// per user mutexes, removed when unused
+type userLocks struct {
+	locker sync.Mutex
+	locks  map[int64]*userLock
+}
+
+type userLock struct {
+	sync.Mutex
+	refs int
+}
+
+// returns unlock func
+func (l *userLocks) lock(telegramUserID int64) func() {
+	l.locker.Lock()
+	if l.locks == nil {
+		l.locks = map[int64]*userLock{}
+	}
+	ul, isExists := l.locks[telegramUserID]
+	if !isExists {
+		ul = &userLock{}
+		l.locks[telegramUserID] = ul
+	}
+	ul.refs++
+	l.locker.Unlock()
+
+	ul.Lock()
+	return func() {
+		ul.Unlock()
+
+		l.locker.Lock()
+		defer l.locker.Unlock()
+
+		ul.refs--
+		if ul.refs == 0 {
+			delete(l.locks, telegramUserID)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package tgfun
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// SessionStore - user sessions storage backend
+type SessionStore interface {
+	// returns nil when session not found
+	GetSession(telegramUserID int64) (*SessionData, error)
+	SaveSession(data SessionData) error
+}
+
+// MemorySessionStore - in-memory sessions storage, lost on restart
+type MemorySessionStore struct {
+	data sync.Map // telegram user ID -> SessionData
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{}
+}
+
+func (s *MemorySessionStore) GetSession(telegramUserID int64) (*SessionData, error) {
+	data, isExists := s.data.Load(telegramUserID)
+	if !isExists {
+		return nil, nil
+	}
+
+	sessionData := data.(SessionData)
+	return &sessionData, nil
+}
+
+func (s *MemorySessionStore) SaveSession(data SessionData) error {
+	s.data.Store(data.TelegramUserID, data)
+	return nil
+}
+
+// SQLSessionStore - sessions storage in MySQL, PostgreSQL or SQLite table.
+// Table schemas can be found in features/sessions*.sql
+type SQLSessionStore struct {
+	db      *sql.DB
+	table   string // quoted
+	dialect sqlDialect
+}
+
+func NewMySQLSessionStore(db *sql.DB, tableName string) *SQLSessionStore {
+	return newSQLSessionStore(db, tableName, sqlDialectMySQL)
+}
+
+func NewPostgresSessionStore(db *sql.DB, tableName string) *SQLSessionStore {
+	return newSQLSessionStore(db, tableName, sqlDialectPostgres)
+}
+
+func NewSQLiteSessionStore(db *sql.DB, tableName string) *SQLSessionStore {
+	return newSQLSessionStore(db, tableName, sqlDialectSQLite)
+}
+
+func newSQLSessionStore(db *sql.DB, tableName string, dialect sqlDialect) *SQLSessionStore {
+	return &SQLSessionStore{
+		db:      db,
+		table:   dialect.quoteIdent(tableName),
+		dialect: dialect,
+	}
+}
+
+// returns nil when session not found
+func (s *SQLSessionStore) GetSession(telegramUserID int64) (*SessionData, error) {
+	sqlQuery := s.dialect.rebind("SELECT data FROM " + s.table + " WHERE tid=? LIMIT 1")
+
+	var rawData string
+	if err := s.db.QueryRow(sqlQuery, telegramUserID).Scan(&rawData); err != nil {
+		if isSQLErrNoRows(err) {
+			return nil, nil
+		}
+		return nil, errors.New("failed to select session: " + err.Error())
+	}
+
+	var data SessionData
+	if err := json.Unmarshal([]byte(rawData), &data); err != nil {
+		return nil, errors.New("failed to decode session: " + err.Error())
+	}
+	return &data, nil
+}
+
+func (s *SQLSessionStore) SaveSession(data SessionData) error {
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return errors.New("failed to encode session: " + err.Error())
+	}
+
+	sqlQuery := s.dialect.rebind(
+		"INSERT INTO " + s.table + " (tid,data,updated_at) VALUES (?,?,?)" +
+			s.dialect.upsert("tid", "data", "updated_at"),
+	)
+	_, err = s.db.Exec(
+		sqlQuery,
+		data.TelegramUserID,
+		string(rawData),
+		toUnixTimestamp(data.UpdatedAt),
+	)
+	if err != nil {
+		return errors.New("failed to save session: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package tgfun
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+)
+
+func TestSessionRecordsVisitsAndVariables(t *testing.T) {
+	// given
+	store := NewMemorySessionStore()
+	f := NewFunnel(FunnelData{}, FunnelScript{
+		"/start": {Message: EventMessage{Text: "hello"}},
+		"offer":  {Message: EventMessage{Text: "offer"}},
+	})
+	require.NoError(t, f.EnableSessionsFeature(SessionsFeature{Store: store, MaxVisits: 2}))
+
+	// when
+	for _, eventID := range []string{"/start", "offer", "offer"} {
+		q, err := f.GetEventQueryHandler(eventID)
+		require.NoError(t, err)
+
+		err = q.inSession(100, func(session *Session) error {
+			// callbacks get the same session by user ID
+			active, err := f.GetUserSession(100)
+			require.NoError(t, err)
+			assert.True(t, session == active)
+
+			active.Set("step", eventID)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	// then
+	session, err := f.GetUserSession(100)
+	require.NoError(t, err)
+	assert.Equal(t, "offer", session.CurrentEventID())
+	assert.Equal(t, "offer", session.Get("step"))
+	assert.Len(t, session.Visits(), 2)
+	assert.False(t, session.IsVisited("/start"))
+	assert.True(t, session.IsVisited("offer"))
+}
+
+func TestSessionNestedEventOfSameUser(t *testing.T) {
+	// given
+	store := NewMemorySessionStore()
+	f := NewFunnel(FunnelData{}, FunnelScript{
+		"/start": {Message: EventMessage{Text: "hello"}},
+		"offer":  {Message: EventMessage{Text: "offer"}},
+	})
+	require.NoError(t, f.EnableSessionsFeature(SessionsFeature{Store: store}))
+
+	outer, err := f.GetEventQueryHandler("/start")
+	require.NoError(t, err)
+	inner, err := f.GetEventQueryHandler("offer")
+	require.NoError(t, err)
+
+	// when
+	done := make(chan error)
+	go func() {
+		done <- outer.inSession(100, func(outerSession *Session) error {
+			// e.g. CustomHandle in OnEvent callback
+			return inner.WithSession(outerSession).inSession(100, func(innerSession *Session) error {
+				assert.True(t, outerSession == innerSession)
+				return nil
+			})
+		})
+	}()
+
+	// then
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("nested event of the same user is locked")
+	}
+
+	session, err := f.GetUserSession(100)
+	require.NoError(t, err)
+	assert.Equal(t, "/start", session.CurrentEventID())
+	assert.True(t, session.IsVisited("offer"))
+}
+
+func TestSessionConcurrentEventsOfSameUser(t *testing.T) {
+	// given
+	store := NewMemorySessionStore()
+	f := NewFunnel(FunnelData{}, FunnelScript{
+		"/start": {Message: EventMessage{Text: "hello"}},
+	})
+	require.NoError(t, f.EnableSessionsFeature(SessionsFeature{Store: store}))
+
+	// when
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		q, err := f.GetEventQueryHandler("/start")
+		require.NoError(t, err)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := q.inSession(100, func(session *Session) error {
+				count, _ := strconv.Atoi(session.Get("count"))
+				time.Sleep(10 * time.Millisecond)
+				session.Set("count", strconv.Itoa(count+1))
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// then
+	session, err := f.GetUserSession(100)
+	require.NoError(t, err)
+	assert.Equal(t, "2", session.Get("count"))
+	assert.Len(t, session.Visits(), 2)
+}
@@ -3,7 +3,9 @@ package tgfun
 import (
 	"database/sql"
 	"errors"
+	"strconv"
 	"strings"
+	"time"
 
 	tb "gopkg.in/telebot.v3"
 )
@@ -12,40 +14,173 @@ func isSQLErrNoRows(err error) bool {
 	return err == sql.ErrNoRows || strings.Contains(err.Error(), "no rows in result set")
 }
 
-func (uft *UsersFeature) getUserData(sender *tb.User) (*userData, error) {
-	user, err := uft.getUserDBData(sender.ID)
-	if err != nil {
-		return nil, err
+// SQL differences between supported databases
+type sqlDialect struct {
+	quoteChar        string
+	isNumberedParam  bool // $1, $2 instead of ?
+	isReturningID    bool // INSERT ... RETURNING id instead of LastInsertId
+	isOnDuplicateKey bool // ON DUPLICATE KEY UPDATE instead of ON CONFLICT
+}
+
+var (
+	sqlDialectMySQL    = sqlDialect{quoteChar: "`", isOnDuplicateKey: true}
+	sqlDialectPostgres = sqlDialect{quoteChar: `"`, isNumberedParam: true, isReturningID: true}
+	sqlDialectSQLite   = sqlDialect{quoteChar: `"`}
+)
+
+// upsert clause for INSERT query, that updates columns on key conflict
+func (d sqlDialect) upsert(keyColumn string, columns ...string) string {
+	updates := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if d.isOnDuplicateKey {
+			updates = append(updates, column+"=VALUES("+column+")")
+		} else {
+			updates = append(updates, column+"=excluded."+column)
+		}
 	}
-	if user != nil {
-		return user, nil
+
+	if d.isOnDuplicateKey {
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
 	}
+	return " ON CONFLICT (" + keyColumn + ") DO UPDATE SET " + strings.Join(updates, ", ")
+}
 
-	u := userData{
-		TelegramID: sender.ID,
-		Name:       sender.FirstName + " " + sender.LastName,
-		TgName:     "@" + sender.Username,
+// quote table name. schema-qualified names like "db.users" are supported
+func (d sqlDialect) quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		part = strings.ReplaceAll(part, d.quoteChar, d.quoteChar+d.quoteChar)
+		parts[i] = d.quoteChar + part + d.quoteChar
 	}
-	if u.Name == " " {
-		u.Name = "anonymous"
+	return strings.Join(parts, ".")
+}
+
+// replace ? placeholders for dialects with numbered params
+func (d sqlDialect) rebind(query string) string {
+	if !d.isNumberedParam {
+		return query
 	}
-	err = uft.saveUser(&u)
-	if err != nil {
-		return nil, err
+
+	var b strings.Builder
+	paramNumber := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+
+		paramNumber++
+		b.WriteString("$" + strconv.Itoa(paramNumber))
 	}
-	return &u, nil
+	return b.String()
 }
 
-// returns nil when user not found
-func (uft *UsersFeature) getUserDBData(telegramUsedID int64) (*userData, error) {
-	user := &userData{
-		TelegramID: telegramUsedID,
+// SQLUserStore - users storage in MySQL, PostgreSQL or SQLite table.
+// Table schemas can be found in features/users*.sql
+type SQLUserStore struct {
+	db      *sql.DB
+	table   string // quoted
+	dialect sqlDialect
+}
+
+func NewMySQLUserStore(db *sql.DB, tableName string) *SQLUserStore {
+	return newSQLUserStore(db, tableName, sqlDialectMySQL)
+}
+
+func NewPostgresUserStore(db *sql.DB, tableName string) *SQLUserStore {
+	return newSQLUserStore(db, tableName, sqlDialectPostgres)
+}
+
+func NewSQLiteUserStore(db *sql.DB, tableName string) *SQLUserStore {
+	return newSQLUserStore(db, tableName, sqlDialectSQLite)
+}
+
+func newSQLUserStore(db *sql.DB, tableName string, dialect sqlDialect) *SQLUserStore {
+	return &SQLUserStore{
+		db:      db,
+		table:   dialect.quoteIdent(tableName),
+		dialect: dialect,
 	}
-	sqlQuery := "SELECT id,name FROM " + uft.TableName + " WHERE tid=? LIMIT 1"
-	err := uft.DBConn.QueryRow(sqlQuery, telegramUsedID).Scan(
+}
+
+func (s *SQLUserStore) query(query string) string {
+	return s.dialect.rebind(query)
+}
+
+const sqlUserColumns = "id,tid,name,tgname,blocked,language," +
+	"first_utm_source,first_utm_campaign,first_utm_content,first_yclid,first_touch_at," +
+	"last_utm_source,last_utm_campaign,last_utm_content,last_yclid,last_touch_at"
+
+type sqlRowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSQLUser(row sqlRowScanner) (User, error) {
+	var user User
+	var firstTouchAt, lastTouchAt int64
+	err := row.Scan(
 		&user.ID,
+		&user.TelegramID,
 		&user.Name,
+		&user.TgName,
+		&user.IsBlocked,
+		&user.Language,
+		&user.FirstTouch.UTMSource,
+		&user.FirstTouch.UTMCampaign,
+		&user.FirstTouch.UTMContent,
+		&user.FirstTouch.Yclid,
+		&firstTouchAt,
+		&user.LastTouch.UTMSource,
+		&user.LastTouch.UTMCampaign,
+		&user.LastTouch.UTMContent,
+		&user.LastTouch.Yclid,
+		&lastTouchAt,
 	)
+	user.FirstTouch.At = fromUnixTimestamp(firstTouchAt)
+	user.LastTouch.At = fromUnixTimestamp(lastTouchAt)
+	return user, err
+}
+
+// values for all columns except id
+func getSQLUserValues(user User) []interface{} {
+	return []interface{}{
+		user.TelegramID,
+		user.Name,
+		user.TgName,
+		user.IsBlocked,
+		user.Language,
+		user.FirstTouch.UTMSource,
+		user.FirstTouch.UTMCampaign,
+		user.FirstTouch.UTMContent,
+		user.FirstTouch.Yclid,
+		toUnixTimestamp(user.FirstTouch.At),
+		user.LastTouch.UTMSource,
+		user.LastTouch.UTMCampaign,
+		user.LastTouch.UTMContent,
+		user.LastTouch.Yclid,
+		toUnixTimestamp(user.LastTouch.At),
+	}
+}
+
+// timestamps are stored as unix seconds to be portable between databases
+func toUnixTimestamp(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func fromUnixTimestamp(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0)
+}
+
+// returns nil when user not found
+func (s *SQLUserStore) GetUser(telegramUserID int64) (*User, error) {
+	sqlQuery := s.query("SELECT " + sqlUserColumns + " FROM " + s.table + " WHERE tid=? LIMIT 1")
+	user, err := scanSQLUser(s.db.QueryRow(sqlQuery, telegramUserID))
 	if err != nil {
 		if isSQLErrNoRows(err) {
 			return nil, nil
@@ -53,12 +188,24 @@ func (uft *UsersFeature) getUserDBData(telegramUsedID int64) (*userData, error)
 		return nil, errors.New("failed to select user data: " + err.Error())
 	}
 
-	return user, nil
+	return &user, nil
 }
 
-func (uft *UsersFeature) saveUser(user *userData) error {
-	sqlQuery := "INSERT INTO " + uft.TableName + " SET tid=?, name=?, tgname=?"
-	result, err := uft.DBConn.Exec(sqlQuery, user.TelegramID, user.Name, user.TgName)
+func (s *SQLUserStore) CreateUser(user *User) error {
+	columns := strings.TrimPrefix(sqlUserColumns, "id,")
+	args := getSQLUserValues(*user)
+	sqlQuery := "INSERT INTO " + s.table + " (" + columns + ") VALUES (" +
+		strings.TrimSuffix(strings.Repeat("?,", len(args)), ",") + ")"
+
+	if s.dialect.isReturningID {
+		err := s.db.QueryRow(s.query(sqlQuery+" RETURNING id"), args...).Scan(&user.ID)
+		if err != nil {
+			return errors.New("failed to save user: " + err.Error())
+		}
+		return nil
+	}
+
+	result, err := s.db.Exec(s.query(sqlQuery), args...)
 	if err != nil {
 		return errors.New("failed to save user: " + err.Error())
 	}
@@ -76,3 +223,126 @@ func (uft *UsersFeature) saveUser(user *userData) error {
 	user.ID = userID
 	return nil
 }
+
+func (s *SQLUserStore) UpdateUser(user User) error {
+	columns := strings.Split(strings.TrimPrefix(sqlUserColumns, "id,tid,"), ",")
+	sqlQuery := s.query(
+		"UPDATE " + s.table + " SET " + strings.Join(columns, "=?, ") + "=? WHERE tid=?",
+	)
+
+	// tid goes to WHERE clause
+	values := getSQLUserValues(user)
+	args := append(values[1:], user.TelegramID)
+	if _, err := s.db.Exec(sqlQuery, args...); err != nil {
+		return errors.New("failed to update user: " + err.Error())
+	}
+	return nil
+}
+
+func (s *SQLUserStore) ListUsers() ([]User, error) {
+	sqlQuery := "SELECT " + sqlUserColumns + " FROM " + s.table + " ORDER BY id"
+	rows, err := s.db.Query(sqlQuery)
+	if err != nil {
+		return nil, errors.New("failed to select users: " + err.Error())
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanSQLUser(rows)
+		if err != nil {
+			return nil, errors.New("failed to scan user: " + err.Error())
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("failed to select users: " + err.Error())
+	}
+	return users, nil
+}
+
+func (s *SQLUserStore) MarkUserBlocked(telegramUserID int64) error {
+	sqlQuery := s.query("UPDATE " + s.table + " SET blocked=? WHERE tid=?")
+	if _, err := s.db.Exec(sqlQuery, true, telegramUserID); err != nil {
+		return errors.New("failed to mark user blocked: " + err.Error())
+	}
+	return nil
+}
+
+// get or create user and record UTM touch from payload
+func (uft *UsersFeature) getUserData(sender *tb.User, payload UserPayload) (*User, error) {
+	user, err := uft.Store.GetUser(sender.ID)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		return user, uft.trackTouch(user, payload)
+	}
+
+	u := User{
+		TelegramID: sender.ID,
+		Name:       sender.FirstName + " " + sender.LastName,
+		TgName:     "@" + sender.Username,
+	}
+	if u.Name == " " {
+		u.Name = "anonymous"
+	}
+	if payload.hasUTM() {
+		u.FirstTouch = payload.touch(time.Now())
+		u.LastTouch = u.FirstTouch
+	}
+
+	err = uft.Store.CreateUser(&u)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// records UTM touch and unblock of the returned user
+func (uft *UsersFeature) trackTouch(user *User, payload UserPayload) error {
+	// user interacts with the bot, so it's unblocked
+	isChanged := user.IsBlocked
+	user.IsBlocked = false
+
+	if payload.hasUTM() {
+		user.LastTouch = payload.touch(time.Now())
+		if user.FirstTouch.IsEmpty() {
+			user.FirstTouch = user.LastTouch
+		}
+		isChanged = true
+	}
+
+	if !isChanged {
+		return nil
+	}
+	return uft.Store.UpdateUser(*user)
+}
+
+// returns payload with stored UTM tags according to attribution model
+func (uft *UsersFeature) attributePayload(
+	telegramUserID int64,
+	payload UserPayload,
+) (UserPayload, error) {
+	if uft.ConversionAttribution == AttributionNone || payload.hasUTM() {
+		return payload, nil
+	}
+
+	user, err := uft.Store.GetUser(telegramUserID)
+	if err != nil || user == nil {
+		return payload, err
+	}
+
+	touch := user.FirstTouch
+	if uft.ConversionAttribution == AttributionLastTouch {
+		touch = user.LastTouch
+	}
+	if touch.IsEmpty() {
+		return payload, nil
+	}
+
+	attributed := touch.payload()
+	attributed.BackLinkEventID = payload.BackLinkEventID
+	attributed.ButtonParam = payload.ButtonParam
+	return attributed, nil
+}
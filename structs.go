@@ -3,7 +3,10 @@ package tgfun
 import (
 	"database/sql"
 	"encoding/json"
-	"log"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/microcosm-cc/bluemonday"
 	tb "gopkg.in/telebot.v3"
@@ -15,16 +18,44 @@ type UserPayload struct {
 	UTMContent      string `json:"t"`
 	BackLinkEventID string `json:"b"`
 	Yclid           string `json:"y"`
+
+	ButtonParam string `json:"-"` // param of clicked button
 }
 
-func (p UserPayload) String() string {
+// Encode - payload in JSON
+func (p UserPayload) Encode() (string, error) {
 	data, err := json.Marshal(p)
 	if err != nil {
-		log.Println("encode payload:", err)
+		return "", fmt.Errorf("encode payload: %w", err)
+	}
+	return string(data), nil
+}
+
+// String - payload in JSON, "{}" when it can't be encoded. Use Encode to get the error
+func (p UserPayload) String() string {
+	data, err := p.Encode()
+	if err != nil {
 		return "{}"
 	}
+	return data
+}
+
+// true when payload has campaign tags, not just a backlink
+func (p UserPayload) hasUTM() bool {
+	if p.UTMSource == "" {
+		return false
+	}
+	return p.BackLinkEventID == "" || p.UTMCampaign != "back"
+}
 
-	return string(data)
+func (p UserPayload) touch(at time.Time) UserTouch {
+	return UserTouch{
+		UTMSource:   p.UTMSource,
+		UTMCampaign: p.UTMCampaign,
+		UTMContent:  p.UTMContent,
+		Yclid:       p.Yclid,
+		At:          at,
+	}
 }
 
 func (p UserPayload) IsEmpty() bool {
@@ -46,6 +77,29 @@ type Funnel struct {
 	features  funnelFeatures
 	sanitizer *bluemonday.Policy
 	resCache  *ResourcesCache
+	resStore  ResourceStore
+
+	errReporter errorReporter
+
+	buttonParams buttonParams
+	templateVars map[string]TemplateVariableCallback
+
+	scriptLocker sync.RWMutex // guards Script and replyButtons after Run
+	replyButtons replyButtons
+	scriptWatch  *scriptWatch
+
+	webhookOnce   sync.Once // webhook is used by Run and http handler goroutines
+	webhook       *webhookPoller
+	webhookServer *http.Server
+
+	lifecycleLocker sync.Mutex
+	lifecycle       funnelLifecycle
+
+	broadcastLocker sync.Mutex
+	broadcast       *Broadcast
+
+	// shared by broadcast and drip, so they don't exceed telegram limits together
+	sendLimiter *sendLimiter
 }
 
 type funnelFeatures struct {
@@ -53,16 +107,23 @@ type funnelFeatures struct {
 	UTM            *UTMTagsFeature
 	UserInput      *UserInputFeature
 	CustomCommands *CustomCommandsFeature
+	Sessions       *SessionsFeature
+	Drip           *DripFeature
+	Metrics        *MetricsFeature
+	Analytics      *AnalyticsFeature
 }
 
 // UsersFeature - feature to enable users db
 type UsersFeature struct {
-	// required
+	// required: Store or MySQL connection with table name
+	Store     UserStore
 	DBConn    *sql.DB
 	TableName string
 
 	// optional
 	AdminChatID int64
+	// fill empty conversion payload with stored user UTM tags
+	ConversionAttribution AttributionModel
 }
 
 type CustomCommandsFeature struct {
@@ -71,24 +132,75 @@ type CustomCommandsFeature struct {
 
 type HandleCommandCallback func(ctx tb.Context, command string, data string) error
 
-type userData struct {
+// User - funnel user stored by users feature
+type User struct {
 	ID         int64
 	TelegramID int64
 	Name       string
 	TgName     string
+	IsBlocked  bool   // user blocked the bot
+	Language   string // chosen by user, empty when not chosen
+
+	// UTM attribution from /start deep links
+	FirstTouch UserTouch
+	LastTouch  UserTouch
+}
+
+// UserTouch - UTM tags of the deep link user came with
+type UserTouch struct {
+	UTMSource   string
+	UTMCampaign string
+	UTMContent  string
+	Yclid       string
+	At          time.Time // zero when user has no UTM tags
+}
+
+func (t UserTouch) IsEmpty() bool {
+	return t.At.IsZero()
 }
 
+func (t UserTouch) payload() UserPayload {
+	return UserPayload{
+		UTMSource:   t.UTMSource,
+		UTMCampaign: t.UTMCampaign,
+		UTMContent:  t.UTMContent,
+		Yclid:       t.Yclid,
+	}
+}
+
+type AttributionModel string
+
+const (
+	AttributionNone       AttributionModel = ""
+	AttributionFirstTouch AttributionModel = "first"
+	AttributionLastTouch  AttributionModel = "last"
+)
+
 // FunnelData - data container for Funnel struct
 type FunnelData struct {
 	Token              string `json:"token"`
 	ImageRoot          string `json:"imageRoot"`
-	ResourcesCachePath string `json:"cachePath"`
+	ResourcesCachePath string `json:"cachePath"` // ignored when resource store is set
+	APIURL             string `json:"apiURL"`    // optional. Bot API server URL, e.g. local server or fake one in tests
+
+	// optional. long polling is used when not set
+	Webhook WebhookData `json:"webhook"`
+
+	// optional. upload script media to cache at startup
+	CacheWarmUp CacheWarmUpData `json:"cacheWarmUp"`
+
+	// optional. default navigation mode of events. send when not set
+	Navigation NavigationMode `json:"navigation"`
+
+	// optional. multi-language funnel settings
+	Localization LocalizationData `json:"localization"`
 }
 
 // FunnelEvent - user interaction event
 type FunnelEvent struct {
 	Message            EventMessage `json:"message"`
 	SubscriptionLocker EventLocker  `json:"locker"`
+	FollowUps          []FollowUp   `json:"followUps"` // optional. requires drip feature
 }
 
 type EventLocker struct {
@@ -103,7 +215,8 @@ type EventMessage struct {
 	Text string `json:"text"`
 
 	// instead of main data
-	Callback BuildMessageCallback `json:"-"` // use it to redefine message
+	Callback          BuildMessageCallback          `json:"-"` // use it to redefine message
+	CallbackWithParam BuildMessageWithParamCallback `json:"-"` // the same, with clicked button param
 
 	// additional events
 	OnEvent OnEventCallback `json:"-"`
@@ -114,6 +227,11 @@ type EventMessage struct {
 	File             FileData             `json:"file"`
 	Audio            AudioData            `json:"audio"`
 	Video            VideoData            `json:"video"`
+	Album            []AlbumItem          `json:"album"` // 2-10 photos and videos
+	Voice            VoiceData            `json:"voice"`
+	VideoNote        VideoNoteData        `json:"videoNote"` // round video, text is not shown
+	Animation        AnimationData        `json:"animation"` // GIF or silent video
+	Sticker          StickerData          `json:"sticker"`   // text is not shown
 	Buttons          []MessageButton      `json:"buttons"`
 	Format           ParseFormat          `json:"format"`
 	ButtonsIsColumns bool                 `json:"buttonsIsColumns"`
@@ -123,6 +241,17 @@ type EventMessage struct {
 	OnConversion     OnConversionCallback `json:"-"`
 	PinThisMessage   bool                 `json:"pin"`
 	DisablePreview   bool                 `json:"disablePreview"`
+	Navigation       NavigationMode       `json:"navigation"` // optional. inherit from funnel
+
+	// optional. reply keyboard settings
+	Keyboard            KeyboardKind `json:"keyboard"` // inline by default
+	OneTimeKeyboard     bool         `json:"oneTimeKeyboard"`
+	PersistentKeyboard  bool         `json:"persistentKeyboard"`
+	KeyboardPlaceholder string       `json:"keyboardPlaceholder"`
+
+	// optional. requires localization
+	Translations map[string]string `json:"translations"` // language -> text
+	SetLanguage  string            `json:"setLanguage"`  // save user language choice. requires users feature
 }
 
 type ImageData struct {
@@ -143,6 +272,29 @@ type VideoData struct {
 	Height           int    `json:"height"`
 }
 
+type VoiceData struct {
+	Path     string `json:"path"` // OGG file encoded with OPUS
+	Duration int    `json:"duration"`
+}
+
+type VideoNoteData struct {
+	Path     string `json:"path"` // square MP4 video
+	Duration int    `json:"duration"`
+	Length   int    `json:"length"` // video width and height
+}
+
+type AnimationData struct {
+	Path     string `json:"path"` // local filename or URL
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Duration int    `json:"duration"`
+}
+
+type StickerData struct {
+	Path   string `json:"path"`   // local WEBP, TGS or WEBM file
+	FileID string `json:"fileID"` // or telegram file ID of sticker
+}
+
 type FileData struct {
 	Path             string `json:"path"`
 	Name             string `json:"name"`
@@ -159,13 +311,21 @@ type OnEventCallback func(tb.Context) error
 
 type BuildMessageCallback func(telegramUserID int64) interface{}
 
+// buttonParam is empty when event is not opened by button with param
+type BuildMessageWithParamCallback func(telegramUserID int64, buttonParam string) interface{}
+
 // MessageButton - funnel event message button
 type MessageButton struct {
 	Text              string `json:"text"`
-	NextMessageID     string `json:"nextID"`     // optional for URL-buttons
-	URL               string `json:"url"`        // optional. only for URL-buttons
-	UseUTMTags        bool   `json:"useUtmTags"` // optional
-	SkipRenderInGraph bool   `json:"skipRender"` // optional
+	NextMessageID     string `json:"nextID"`          // optional for URL-buttons
+	Param             string `json:"param"`           // optional. passed to next event
+	URL               string `json:"url"`             // optional. only for URL-buttons
+	UseUTMTags        bool   `json:"useUtmTags"`      // optional
+	SkipRenderInGraph bool   `json:"skipRender"`      // optional
+	RequestContact    bool   `json:"requestContact"`  // optional. only for reply keyboard
+	RequestLocation   bool   `json:"requestLocation"` // optional. only for reply keyboard
+
+	Translations map[string]string `json:"translations"` // optional. language -> text
 }
 
 // FunnelScript - funnel scenario
@@ -180,14 +340,28 @@ type QueryHandler struct {
 	Menu           *tb.ReplyMarkup
 	ParseMode      tb.ParseMode
 	Bot            *tb.Bot
-	FilesRoot      string // inherit from Funnel
+	FilesRoot      string           // inherit from Funnel
+	Navigation     NavigationMode   // inherit from Funnel
+	Localization   LocalizationData // inherit from Funnel
 	Features       *funnelFeatures
 	sanitizer      *bluemonday.Policy
 	resCache       *ResourcesCache
+	buttonParams   *buttonParams
+	templateVars   map[string]TemplateVariableCallback
+	errReporter    *errorReporter
+
+	telegramUserID    int64       // recipient, set on delivery
+	payload           UserPayload // payload of the built event
+	redirectedEventID string      // locker event sent instead of this one
+	editable          *tb.Message // message with clicked button
+	sender            *tb.User    // nil when event is sent without user update
+	session           *Session    // session of the parent event, its user is locked already
+	albumMessages     []tb.Message
 }
 
 type fileState struct {
 	IsUsed        bool
 	Type          MessageType
 	LocalFilePath string
+	Album         []fileState // album items
 }
@@ -3,17 +3,16 @@ package tgfun
 import (
 	"errors"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
 	tb "gopkg.in/telebot.v3"
 )
 
-func (f *Funnel) formatMessages() {
-	for key, value := range f.Script {
-		value.Message.Text = formatMessage(f.Script[key].Message.Text)
-		f.Script[key] = value
+func formatScript(script FunnelScript) {
+	for key, value := range script {
+		value.Message.Text = formatMessage(value.Message.Text)
+		script[key] = value
 	}
 }
 
@@ -31,41 +30,77 @@ func formatMessage(message string) string {
 
 // Run funnel. This is a non-blocking operation
 func (f *Funnel) Run() error {
+	if err := f.markRunning(); err != nil {
+		return err
+	}
+
+	if err := f.run(); err != nil {
+		f.markStopped()
+		return err
+	}
+	return nil
+}
+
+func (f *Funnel) run() error {
 	if f.Data.Token == "" {
 		return errors.New("bot token is not set")
 	}
 
-	f.formatMessages()
+	formatScript(f.Script)
+	f.buttonParams.registerScript(f.Script)
+	f.replyButtons = newReplyButtons(f.Script)
+
+	validation := f.Validate()
+	for _, issue := range validation.Warnings {
+		f.errReporter.getLogger().Warn(issue.String())
+	}
+	if err := validation.Err(); err != nil {
+		return err
+	}
+
+	poller, err := f.getPoller()
+	if err != nil {
+		return fmt.Errorf("setup poller: %w", err)
+	}
 
-	var err error
 	f.bot, err = tb.NewBot(tb.Settings{
-		Token:  f.Data.Token,
-		Poller: &tb.LongPoller{Timeout: 10 * time.Second},
+		URL:         f.Data.APIURL,
+		Token:       f.Data.Token,
+		Poller:      f.newInFlightPoller(poller),
+		Synchronous: true, // handlers are run in goroutines by the poller
+		OnError:     f.errReporter.handleBotError,
 	})
 	if err != nil {
 		return errors.New("failed to setup telegram bot: " + err.Error())
 	}
+	f.addShutdownHook(f.cancelBroadcast)
 
-	f.resCache = NewResourceCache(
-		f.Data.ResourcesCachePath,
-		f.Data.ImageRoot,
-	)
-
-	if err := f.handleStartMessage(); err != nil {
-		return fmt.Errorf("handle start message: %w", err)
+	if f.resStore != nil {
+		f.resCache = NewResourceCacheWithStore(f.resStore, f.Data.ImageRoot)
+	} else {
+		f.resCache = newResourceCache(
+			f.Data.ResourcesCachePath,
+			f.Data.ImageRoot,
+			&f.errReporter,
+		)
 	}
+	f.resCache.errReporter = &f.errReporter
+	f.resCache.metrics = f.features.getMetrics()
 
-	if err := f.handleScriptEvents(); err != nil {
-		return fmt.Errorf("handle script events: %w", err)
-	}
+	f.bot.Handle(tb.OnCallback, f.handleCallback)
 
 	if f.OnWebAppCallback != nil {
 		f.bot.Handle(tb.OnWebApp, f.OnWebAppCallback)
 	}
 
 	f.handleTextEvents()
+	f.handleReplyButtons()
 
 	go f.bot.Start()
+	f.startDripScheduler()
+	f.startCacheWarmUp()
+	f.startScriptWatch()
+	f.startWebhookListener()
 	return nil
 }
 
@@ -73,43 +108,69 @@ func (f *Funnel) SetupOnWebAppLaunchCallback(cb func(ctx tb.Context) error) {
 	f.OnWebAppCallback = cb
 }
 
-func (f *Funnel) handleStartMessage() error {
-	parseMode := tb.ParseMode(parseMode)
+func (f *Funnel) handleTextEvents() {
+	f.bot.Handle(tb.OnText, f.handleTextMessage)
+}
+
+// script events are looked up on every update, so reloaded script
+// is used without handlers registration
+func (f *Funnel) handleCallback(ctx tb.Context) error {
+	// telebot encodes data button as "\f" + unique + "|" + data
+	data := ctx.Callback().Data
+	if !strings.HasPrefix(data, "\f") {
+		return nil
+	}
+	eventMessageID, payload, _ := strings.Cut(strings.TrimPrefix(data, "\f"), "|")
 
-	startEvent, isStartMessageFound := f.Script[startMessageCode]
-	if !isStartMessageFound {
-		return errors.New("start message not found in script")
+	q, err := f.GetEventQueryHandler(eventMessageID)
+	if err != nil {
+		// button of the removed event
+		_ = ctx.Respond()
+		return fmt.Errorf("get query handler: %w", err)
 	}
 
-	return f.handleEvent(startMessageCode, startEvent, parseMode)
+	btnIndex, btnText := getCallbackButton(ctx.Callback())
+	f.onButtonClick(
+		ctx, eventMessageID,
+		f.getScriptButtonText(eventMessageID, btnIndex, btnText), btnText,
+	)
+	ctx.Callback().Unique = eventMessageID
+	ctx.Callback().Data = payload
+	return q.handleButton(ctx)
 }
 
-func (f *Funnel) handleScriptEvents() error {
-	parseMode := tb.ParseMode(parseMode)
+// returns command event ID, e.g. "/help" for "/help@bot payload"
+func getCommandEventID(text string) string {
+	if !strings.HasPrefix(text, "/") {
+		return ""
+	}
 
-	for eventMessageID, eventData := range f.Script {
-		if eventMessageID == startMessageCode {
-			continue
-		}
+	command, _, _ := strings.Cut(strings.Fields(text)[0], "@")
+	return command
+}
 
-		err := f.handleEvent(eventMessageID, eventData, parseMode)
-		if err != nil {
-			return fmt.Errorf("handle event: %w", err)
+func (f *Funnel) handleTextMessage(ctx tb.Context) error {
+	script := f.getScript()
+
+	if commandEventID := getCommandEventID(ctx.Text()); commandEventID != "" {
+		if _, isEventExists := script[commandEventID]; isEventExists {
+			q, err := f.GetEventQueryHandler(commandEventID)
+			if err != nil {
+				return fmt.Errorf("get query handler: %w", err)
+			}
+
+			return q.handleMessage(ctx)
 		}
 	}
 
-	return nil
-}
-
-func (f *Funnel) handleTextEvents() {
-	f.bot.Handle(tb.OnText, f.handleTextMessage)
-}
+	if btn, isButton := f.getReplyButtons().texts[ctx.Text()]; isButton {
+		return f.handleReplyButton(ctx, btn)
+	}
 
-func (f *Funnel) handleTextMessage(ctx tb.Context) error {
 	sanitizedText := strings.Trim(f.sanitizer.Sanitize(ctx.Text()), " ")
 	eventMessageID := strings.ToLower(sanitizedText)
 
-	if _, isEventExists := f.Script[eventMessageID]; isEventExists {
+	if _, isEventExists := script[eventMessageID]; isEventExists {
 		q, err := f.GetEventQueryHandler(eventMessageID)
 		if err != nil {
 			return fmt.Errorf("get query handler: %w", err)
@@ -128,14 +189,14 @@ func (f *Funnel) handleTextMessage(ctx tb.Context) error {
 		}
 	}
 
+	if f.isAdminMessage(ctx) {
+		return f.handleAdminMessage(ctx)
+	}
+
 	if f.features.IsUserInputFeatureActive() {
 		return f.handleCustomUserInput(ctx, sanitizedText)
 	}
 	return nil
-
-	//if c.Chat().ID == f.features.Users.AdminChatID {
-	//	return f.handleAdminMessage(c)
-	//}
 }
 
 // returns processed status
@@ -178,7 +239,7 @@ func (f *Funnel) handleCustomUserInput(ctx tb.Context, input string) error {
 
 func (f *Funnel) sendEventToUser(ctx tb.Context, eventID string) error {
 	if eventID == "" {
-		log.Println("event ID is not set. skip")
+		f.errReporter.getLogger().Warn("event ID is not set. skip")
 		return nil
 	}
 
@@ -196,24 +257,38 @@ func (f *Funnel) sendEventToUser(ctx tb.Context, eventID string) error {
 func (f *Funnel) GetEventQueryHandler(
 	eventMessageID string,
 ) (*QueryHandler, error) {
-	if _, isEventExists := f.Script[eventMessageID]; !isEventExists {
+	event, isEventExists := f.getScript()[eventMessageID]
+	if !isEventExists {
 		return nil, fmt.Errorf("event %q not exists in funnel", eventMessageID)
 	}
 
+	return f.newQueryHandler(eventMessageID, event), nil
+}
+
+// handler for any event, including events out of the script
+func (f *Funnel) newQueryHandler(
+	eventMessageID string,
+	event FunnelEvent,
+) *QueryHandler {
 	menu := tb.ReplyMarkup{}
 
 	return &QueryHandler{
-		Script:         f.Script,
+		Script:         f.getScript(),
 		EventMessageID: eventMessageID,
-		EventData:      f.Script[eventMessageID],
+		EventData:      event,
 		Menu:           &menu,
 		ParseMode:      parseMode,
 		Bot:            f.bot,
 		FilesRoot:      f.Data.ImageRoot,
+		Navigation:     f.Data.Navigation,
+		Localization:   f.Data.Localization,
 		Features:       &f.features,
 		sanitizer:      f.sanitizer,
 		resCache:       f.resCache,
-	}, nil
+		buttonParams:   &f.buttonParams,
+		templateVars:   f.templateVars,
+		errReporter:    &f.errReporter,
+	}
 }
 
 func (q *QueryHandler) createChildHandler(messageID string) (*QueryHandler, error) {
@@ -229,44 +304,22 @@ func (q *QueryHandler) createChildHandler(messageID string) (*QueryHandler, erro
 		ParseMode:      q.ParseMode,
 		Bot:            q.Bot,
 		FilesRoot:      q.FilesRoot,
+		Navigation:     q.Navigation,
+		Localization:   q.Localization,
 		Features:       q.Features,
 		sanitizer:      q.sanitizer,
 		resCache:       q.resCache,
+		buttonParams:   q.buttonParams,
+		templateVars:   q.templateVars,
+		errReporter:    q.errReporter,
+		telegramUserID: q.telegramUserID,
+		session:        q.session,
 	}, nil
 }
 
-func (f *Funnel) handleEvent(
-	eventMessageID string,
-	_ FunnelEvent,
-	_ tb.ParseMode,
-) error {
-	menu := tb.ReplyMarkup{}
-
-	// create message handler
-	q, err := f.GetEventQueryHandler(eventMessageID)
-	if err != nil {
-		return fmt.Errorf("get query handler: %w", err)
-	}
-
-	// build message
-	if strings.Contains(eventMessageID, "/") {
-		// command or text message
-		f.bot.Handle(eventMessageID, q.handleMessage)
-		return nil
-	}
-
-	// button query
-	btnListener := menu.Data("listener", eventMessageID)
-	f.bot.Handle(&btnListener, q.handleButton)
-
-	// text query
-	f.bot.Handle(strings.ToLower(eventMessageID), q.handleMessage)
-	return nil
-}
-
 func (q *QueryHandler) actionNotify(telegramUserID int64, action tb.ChatAction) {
 	if err := q.Bot.Notify(tb.ChatID(telegramUserID), action); err != nil {
-		log.Println("notify:", err)
+		q.reportError(ErrorStageSend, fmt.Errorf("notify: %w", err))
 	}
 }
 
@@ -277,33 +330,37 @@ func (q *QueryHandler) makeConversion(
 ) {
 	err := q.EventData.Message.OnConversion(telegramUserID, conversion, payload)
 	if err != nil {
-		log.Printf(
-			"handle conversion %q in tgfun: %s\n",
-			conversion,
-			err.Error(),
+		q.reportError(
+			ErrorStageConversion,
+			fmt.Errorf("handle conversion %q: %w", conversion, err),
 		)
 	}
 }
 
 func (q *QueryHandler) handleConversions(telegramUserID int64, payload UserPayload) {
-	if q.EventData.Message.Conversion != "" {
+	if q.Features.Users != nil {
+		attributed, err := q.Features.Users.attributePayload(telegramUserID, payload)
+		if err != nil {
+			q.reportError(ErrorStageConversion, fmt.Errorf("attribute conversion: %w", err))
+		}
+		payload = attributed
+	}
+
+	for _, conversion := range q.EventData.Message.getConversions() {
 		q.makeConversion(
 			telegramUserID,
-			q.EventData.Message.Conversion,
+			conversion,
 			payload,
 		)
-		return
 	}
+}
 
-	if len(q.EventData.Message.Conversions) > 0 {
-		for _, conversion := range q.EventData.Message.Conversions {
-			q.makeConversion(
-				telegramUserID,
-				conversion,
-				payload,
-			)
-		}
+// single conversion overrides the list
+func (msg EventMessage) getConversions() []string {
+	if msg.Conversion != "" {
+		return []string{msg.Conversion}
 	}
+	return msg.Conversions
 }
 
 // returns message, is local file used
@@ -311,9 +368,20 @@ func (q *QueryHandler) buildMessage(
 	telegramUserID int64,
 	payload UserPayload,
 ) (interface{}, fileState) {
+	q.telegramUserID = telegramUserID
+	q.payload = payload
+	q.localize(telegramUserID)
+	q.renderTemplates(telegramUserID, payload)
+
 	if q.EventData.Message.Callback != nil {
 		return q.EventData.Message.Callback(telegramUserID), fileState{}
 	}
+	if q.EventData.Message.CallbackWithParam != nil {
+		return q.EventData.Message.CallbackWithParam(
+			telegramUserID,
+			payload.ButtonParam,
+		), fileState{}
+	}
 
 	if q.EventData.Message.OnConversion != nil {
 		q.handleConversions(telegramUserID, payload)
@@ -343,10 +411,36 @@ func (q *QueryHandler) buildMessage(
 		q.actionNotify(telegramUserID, tb.UploadingAudio)
 
 		return q.getAudioMessage(q.EventData.Message, q.FilesRoot)
+	case MessageTypeAlbum:
+		q.actionNotify(telegramUserID, tb.UploadingPhoto)
+
+		return q.getAlbumMessage(q.EventData.Message, q.FilesRoot)
+	case MessageTypeVoice:
+		q.actionNotify(telegramUserID, tb.RecordingAudio)
+
+		return q.getVoiceMessage(q.EventData.Message, q.FilesRoot)
+	case MessageTypeVideoNote:
+		q.actionNotify(telegramUserID, tb.RecordingVNote)
+
+		return q.getVideoNoteMessage(q.EventData.Message, q.FilesRoot)
+	case MessageTypeAnimation:
+		q.actionNotify(telegramUserID, tb.UploadingVideo)
+
+		return q.getAnimationMessage(q.EventData.Message, q.FilesRoot)
+	case MessageTypeSticker:
+		q.actionNotify(telegramUserID, tb.ChoosingSticker)
+
+		return q.getStickerMessage(q.EventData.Message, q.FilesRoot)
 	}
 }
 
 func (q *QueryHandler) CustomHandle(telegramUserID int64) error {
+	return q.deliver(telegramUserID, func(*Session) error {
+		return q.customHandle(telegramUserID)
+	})
+}
+
+func (q *QueryHandler) customHandle(telegramUserID int64) error {
 	msg, st := q.buildMessage(telegramUserID, UserPayload{})
 	q.buildButtons(telegramUserID)
 
@@ -357,7 +451,7 @@ func (q *QueryHandler) CustomHandle(telegramUserID int64) error {
 
 	response, err := q.send(telegramUserID, msg, format)
 	if err != nil {
-		return fmt.Errorf("send: %w", err)
+		return q.newError(ErrorStageSend, err)
 	}
 
 	q.ActualizeCache(st, response)
@@ -368,13 +462,22 @@ func (q *QueryHandler) ActualizeCache(st fileState, response *tb.Message) {
 	if !st.IsUsed || response == nil {
 		return
 	}
+	if st.Type == MessageTypeAlbum {
+		q.actualizeAlbumCache(st)
+		return
+	}
 
 	resFile, found := findFileInMessage(response, st)
 	if !found {
 		return
 	}
 
-	q.resCache.Actualize(st.LocalFilePath, resFile)
+	if !q.resCache.IsNeedUpdate(st.LocalFilePath, resFile) {
+		return
+	}
+	if err := q.resCache.Update(st.LocalFilePath, resFile); err != nil {
+		q.reportError(ErrorStageCache, fmt.Errorf("update %q: %w", st.LocalFilePath, err))
+	}
 }
 
 func (q *QueryHandler) handleMessage(ctx tb.Context) error {
@@ -383,7 +486,7 @@ func (q *QueryHandler) handleMessage(ctx tb.Context) error {
 
 		payload, err := FilterUserPayload(sanitizedPayload)
 		if err != nil {
-			log.Println("filter user payload:", sanitizedPayload, "error:", err)
+			q.reportError(ErrorStageBuild, fmt.Errorf("filter user payload %q: %w", sanitizedPayload, err))
 			return q.buildAndSend(ctx, payload)
 		}
 
@@ -409,7 +512,7 @@ func (q *QueryHandler) handleMessage(ctx tb.Context) error {
 		}
 
 		// эвент не найден, продолжим обработку стартового сообщения
-		log.Printf("event %q not found\n", eventID)
+		q.errReporter.getLogger().Warn("backlink event not found", "eventID", eventID)
 	}
 
 	return q.buildAndSend(ctx, UserPayload{})
@@ -429,76 +532,137 @@ func (q *QueryHandler) handleChildQuery(
 }
 
 func (q *QueryHandler) buildAndSend(ctx tb.Context, payload UserPayload) error {
+	return q.deliver(ctx.Sender().ID, func(session *Session) error {
+		if session != nil {
+			ctx.Set(sessionContextKey, session)
+		}
+		return q.buildAndSendEvent(ctx, payload)
+	})
+}
+
+func (q *QueryHandler) buildAndSendEvent(ctx tb.Context, payload UserPayload) error {
+	q.sender = ctx.Sender()
 	msg, st := q.buildMessage(ctx.Sender().ID, payload)
 	q.buildButtons(ctx.Sender().ID)
 
 	if q.EventData.Message.OnEvent != nil {
 		if err := q.EventData.Message.OnEvent(ctx); err != nil {
-			return fmt.Errorf("handle event custom callback: %w", err)
+			return q.newError(ErrorStageBuild, fmt.Errorf("handle event custom callback: %w", err))
 		}
 	}
 
 	if q.Features.Users != nil {
-		_, err := q.Features.Users.getUserData(ctx.Sender())
+		_, err := q.Features.Users.getUserData(ctx.Sender(), payload)
 		if err != nil {
-			return fmt.Errorf("get user data: %w", err)
+			return q.newError(ErrorStageBuild, fmt.Errorf("get user data: %w", err))
 		}
 	}
 
 	response, err := q.sendWithCheck(ctx, msg, payload)
 	if err != nil {
-		return fmt.Errorf("send: %w", err)
+		return q.newError(ErrorStageSend, err)
 	}
 
 	q.ActualizeCache(st, response)
 	return nil
 }
 
-/*
-func (f *Funnel) handleAdminMessage(c tb.Context) error {
-	if !strings.HasPrefix(c.Text(), adminPostToAllPrefix) {
-		if _, err := f.bot.Send(c.Sender(), "Не могу разобрать сообщение"); err != nil {
-			return fmt.Errorf("send message: %w", err)
+func (q *QueryHandler) handleButton(c tb.Context) error {
+	defer func() {
+		if err := c.Respond(); err != nil {
+			q.reportError(ErrorStageSend, fmt.Errorf("respond to callback: %w", err))
 		}
-		return nil
+	}()
+
+	return q.deliver(c.Sender().ID, func(session *Session) error {
+		if session != nil {
+			c.Set(sessionContextKey, session)
+		}
+		return q.sendButtonEvent(c)
+	})
+}
+
+func (q *QueryHandler) sendButtonEvent(c tb.Context) error {
+	if c.Callback() != nil {
+		q.editable = c.Callback().Message
 	}
 
-	adminPostText := strings.Replace(c.Text(), adminPostToAllPrefix, "", 1)
-	telegramUserIDs, err := f.features.Users.getUsersTelegramIDs()
-	if err != nil {
-		_, err := f.bot.Send(
-			tb.ChatID(f.features.Users.AdminChatID),
-			err.Error(),
-			tb.ModeMarkdown,
+	// button events doesn't have UTM payload
+	buttonParam, isFound := q.buttonParams.decode(c.Data())
+	if !isFound {
+		q.errReporter.getLogger().Warn(
+			"button param not found", "data", c.Data(), "eventID", q.EventMessageID,
 		)
-		return fmt.Errorf("send message: %w", err)
 	}
-
-	for _, telegramUserID := range telegramUserIDs {
-		_, err := f.bot.Send(tb.ChatID(telegramUserID), adminPostText, tb.ModeMarkdown)
-		if err != nil {
-			return fmt.Errorf("send message: %w", err)
-		}
+	payload := UserPayload{ButtonParam: buttonParam}
+	if payload.ButtonParam != "" {
+		c.Set(buttonParamContextKey, payload.ButtonParam)
 	}
-	return nil
-}*/
 
-func (q *QueryHandler) handleButton(c tb.Context) error {
-	defer c.Respond()
-
-	// button events doesn't have payload
-	msg, st := q.buildMessage(c.Sender().ID, UserPayload{})
+	q.sender = c.Sender()
+	msg, st := q.buildMessage(c.Sender().ID, payload)
 	q.buildButtons(c.Sender().ID)
 
-	response, err := q.sendWithCheck(c, msg, UserPayload{})
+	if q.EventData.Message.OnEvent != nil {
+		if err := q.EventData.Message.OnEvent(c); err != nil {
+			return q.newError(ErrorStageBuild, fmt.Errorf("handle event custom callback: %w", err))
+		}
+	}
+
+	response, err := q.sendWithCheck(c, msg, payload)
 	if err != nil {
-		return fmt.Errorf("send with check: %w", err)
+		return q.newError(ErrorStageSend, err)
 	}
 
 	q.ActualizeCache(st, response)
 	return nil
 }
 
+// send event to user in session and run delivery hooks on success
+func (q *QueryHandler) deliver(
+	telegramUserID int64,
+	send func(session *Session) error,
+) error {
+	q.telegramUserID = telegramUserID
+
+	if err := q.inSession(telegramUserID, send); err != nil {
+		return err
+	}
+
+	q.onDelivered(telegramUserID)
+	return nil
+}
+
+// returns event actually sent to user, e.g. locker event instead of requested one
+func (q *QueryHandler) getDeliveredEvent() (string, FunnelEvent) {
+	if q.redirectedEventID != "" {
+		return q.redirectedEventID, q.Script[q.redirectedEventID]
+	}
+	return q.EventMessageID, q.EventData
+}
+
+func (q *QueryHandler) onDelivered(telegramUserID int64) {
+	eventID, event := q.getDeliveredEvent()
+	if eventID == "" {
+		return // event out of script, e.g. broadcast text
+	}
+	metrics := q.Features.getMetrics()
+	metrics.onDelivered(eventID)
+	for _, conversion := range event.Message.getConversions() {
+		metrics.onConversion(conversion)
+	}
+	if q.Features.IsAnalyticsFeatureActive() {
+		q.recordDelivery(telegramUserID, eventID, event)
+	}
+
+	if q.Features.IsDripFeatureActive() {
+		err := q.Features.Drip.onEventDelivered(telegramUserID, eventID, event)
+		if err != nil {
+			q.reportError(ErrorStageDrip, err)
+		}
+	}
+}
+
 func (q *QueryHandler) sendWithCheck(
 	c tb.Context,
 	msg interface{},
@@ -511,14 +675,16 @@ func (q *QueryHandler) sendWithCheck(
 
 	lockerPassed, err := q.checkLocker(c)
 	if err != nil {
-		log.Println(err)
+		q.reportError(ErrorStageLocker, err)
 	}
 	if !lockerPassed {
 		lockerMessageHandler, err := q.createChildHandler(q.EventData.SubscriptionLocker.
 			LockerMessageID)
 		if err != nil {
-			log.Println(err)
+			q.reportError(ErrorStageLocker, err)
 		} else {
+			lockerMessageHandler.editable = q.editable
+			lockerMessageHandler.sender = q.sender
 			msg, st := lockerMessageHandler.buildMessage(
 				c.Sender().ID,
 				payload,
@@ -534,7 +700,8 @@ func (q *QueryHandler) sendWithCheck(
 				return nil, fmt.Errorf("send locker event: %w", err)
 			}
 
-			q.ActualizeCache(st, response)
+			lockerMessageHandler.ActualizeCache(st, response)
+			q.redirectedEventID = lockerMessageHandler.EventMessageID
 			return nil, nil
 		}
 	}
@@ -556,6 +723,14 @@ func (q *QueryHandler) checkLocker(c tb.Context) (bool, error) {
 	}
 
 	isJoined, err := q.isUserJoined(q.EventData.SubscriptionLocker.ChatID, c.Sender())
+	switch {
+	case err != nil:
+		q.Features.getMetrics().onLockerCheck("failed")
+	case isJoined:
+		q.Features.getMetrics().onLockerCheck("passed")
+	default:
+		q.Features.getMetrics().onLockerCheck("locked")
+	}
 	if err != nil {
 		return false, fmt.Errorf(
 			"check user joined %v: %w",
@@ -588,23 +763,37 @@ func (q *QueryHandler) send(
 	message interface{},
 	args ...interface{},
 ) (*tb.Message, error) {
-	args = append(args, q.Menu)
-
-	messageResponse, err := q.Bot.Send(tb.ChatID(chatID), message, args...)
+	var messageResponse *tb.Message
+	var err error
+	sendStart := time.Now()
+	if album, isAlbum := message.(tb.Album); isAlbum {
+		messageResponse, err = q.sendAlbum(chatID, album, args...)
+	} else {
+		args = append(args, q.Menu)
+		messageResponse, err = q.editOrSend(chatID, message, args...)
+	}
+	q.Features.getMetrics().onSend(getSentMessageType(message), time.Since(sendStart), err)
 	if err != nil {
 		return nil, fmt.Errorf("send message: %w", err)
 	}
 
 	if q.EventData.Message.PinThisMessage {
 		if err := q.Bot.Pin(messageResponse); err != nil {
-			log.Printf("pin message: %s\n", err.Error())
+			q.reportError(ErrorStageSend, fmt.Errorf("pin message: %w", err))
 		}
 	}
 	return messageResponse, nil
 }
 
 func (q *QueryHandler) buildButtons(telegramUserID int64) {
-	if q.EventData.Message.Buttons == nil {
+	switch q.EventData.Message.Keyboard {
+	case KeyboardRemove:
+		q.Menu.RemoveKeyboard = true
+		return
+	case KeyboardReply:
+		if len(q.EventData.Message.Buttons) > 0 {
+			q.buildReplyButtons()
+		}
 		return
 	}
 
@@ -612,64 +801,66 @@ func (q *QueryHandler) buildButtons(telegramUserID int64) {
 		return
 	}
 
-	if len(q.EventData.Message.Buttons) > 0 {
-		var rows []tb.Row
-		var btns []tb.Btn
-
-		for _, btnData := range q.EventData.Message.Buttons {
-			var btn tb.Btn
-			if btnData.URL == "" {
-				// next event button
-				btn = q.Menu.Data(btnData.Text, btnData.NextMessageID)
-			} else {
-				// URL button
-				btnURL := btnData.URL
-				if q.Features.IsUTMTagsFeatureActive() && btnData.UseUTMTags {
-					utmTags := q.Features.UTM.GetUserUTMTags(telegramUserID)
-					newURL, err := addUtmTags(btnURL, utmTags)
-					if err != nil {
-						log.Println("failed to add utm tags to url:", err)
-					}
-
-					btnURL = newURL
+	var btns []tb.Btn
+	for _, btnData := range q.EventData.Message.Buttons {
+		var btn tb.Btn
+		if btnData.URL == "" {
+			// next event button
+			var data []string
+			if btnData.Param != "" {
+				data = append(data, q.buttonParams.encode(btnData.NextMessageID, btnData.Param))
+			}
+			btn = q.Menu.Data(btnData.Text, btnData.NextMessageID, data...)
+		} else {
+			// URL button
+			btnURL := btnData.URL
+			if q.Features.IsUTMTagsFeatureActive() && btnData.UseUTMTags {
+				utmTags := q.Features.UTM.GetUserUTMTags(telegramUserID)
+				newURL, err := addUtmTags(btnURL, utmTags)
+				if err != nil {
+					q.reportError(ErrorStageBuild, fmt.Errorf("add utm tags to url: %w", err))
 				}
 
-				btn = q.Menu.URL(btnData.Text, btnURL)
+				btnURL = newURL
 			}
 
-			rows = append(rows, q.Menu.Row(btn))
-			btns = append(btns, btn)
+			btn = q.Menu.URL(btnData.Text, btnURL)
 		}
 
-		// handle layout style
-		if q.EventData.Message.ButtonsIsColumns {
-			q.Menu.Inline(rows...)
-		} else {
-			if q.EventData.Message.ButtonsSplit == 0 {
-				q.Menu.Inline(q.Menu.Row(btns...))
-			}
+		btns = append(btns, btn)
+	}
 
-			var rows []tb.Row
-			var btnsInRow []tb.Btn
-			for _, btn := range btns {
-				btnsInRow = append(btnsInRow, btn)
-
-				if len(btnsInRow) >= q.EventData.Message.ButtonsSplit {
-					rows = append(
-						rows,
-						q.Menu.Row(btnsInRow...),
-					)
-					btnsInRow = make([]tb.Btn, 0)
-				}
-			}
-			if len(btnsInRow) > 0 {
-				rows = append(
-					rows,
-					q.Menu.Row(btnsInRow...),
-				)
-			}
+	q.Menu.Inline(q.layoutButtons(btns)...)
+}
+
+// handle layout style: one button per row for columns,
+// otherwise ButtonsSplit buttons per row
+func (q *QueryHandler) layoutButtons(btns []tb.Btn) []tb.Row {
+	var rows []tb.Row
+	if q.EventData.Message.ButtonsIsColumns {
+		for _, btn := range btns {
+			rows = append(rows, q.Menu.Row(btn))
+		}
+		return rows
+	}
+
+	var btnsInRow []tb.Btn
+	for _, btn := range btns {
+		btnsInRow = append(btnsInRow, btn)
 
-			q.Menu.Inline(rows...)
+		if len(btnsInRow) >= q.EventData.Message.ButtonsSplit {
+			rows = append(
+				rows,
+				q.Menu.Row(btnsInRow...),
+			)
+			btnsInRow = make([]tb.Btn, 0)
 		}
 	}
+	if len(btnsInRow) > 0 {
+		rows = append(
+			rows,
+			q.Menu.Row(btnsInRow...),
+		)
+	}
+	return rows
 }
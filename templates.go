@@ -0,0 +1,297 @@
+package tgfun
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const sessionTemplatePrefix = "session."
+
+var (
+	templateVarRegexp = regexp.MustCompile(`\{\{\s*([\w.]+)\s*\}\}`)
+
+	markdownEscaper = strings.NewReplacer(
+		"_", "\\_",
+		"*", "\\*",
+		"`", "\\`",
+		"[", "\\[",
+	)
+
+	builtinTemplateVars = map[string]bool{
+		"user_id":      true,
+		"first_name":   true,
+		"last_name":    true,
+		"username":     true,
+		"utm_source":   true,
+		"utm_campaign": true,
+		"utm_content":  true,
+		"user_input":   true,
+		"button_param": true,
+	}
+)
+
+// TemplateVariableCallback - returns custom template variable value for user
+type TemplateVariableCallback func(telegramUserID int64) (string, error)
+
+// RegisterTemplateVariable - add custom variable, that is used as {{name}}
+// in message text, captions, button texts and URLs.
+// Built-in variables can be redefined
+func (f *Funnel) RegisterTemplateVariable(name string, cb TemplateVariableCallback) {
+	if f.templateVars == nil {
+		f.templateVars = map[string]TemplateVariableCallback{}
+	}
+	f.templateVars[name] = cb
+}
+
+func hasTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// returns template variables names used in text
+func getTemplateVars(text string) []string {
+	var names []string
+	for _, match := range templateVarRegexp.FindAllStringSubmatch(text, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+func isMessageTemplate(msg EventMessage) bool {
+	if hasTemplate(msg.Text) {
+		return true
+	}
+	for _, btn := range msg.Buttons {
+		if hasTemplate(btn.Text) || hasTemplate(btn.URL) {
+			return true
+		}
+	}
+	for _, item := range msg.Album {
+		if hasTemplate(item.Caption) {
+			return true
+		}
+	}
+	return false
+}
+
+// escape variable value for message parse mode
+func getTemplateEscaper(format string) func(string) string {
+	switch ParseFormat(format) {
+	case ParseFormatHTML:
+		return html.EscapeString
+	case ParseFormatMarkdown:
+		return markdownEscaper.Replace
+	}
+	return func(value string) string { return value }
+}
+
+// variables are resolved lazily, so callbacks and stores are used
+// only for variables in the message
+type templateVars struct {
+	q              *QueryHandler
+	telegramUserID int64
+	payload        UserPayload
+	values         map[string]string
+	storedUser     *User
+}
+
+// render templates of event message text, captions and buttons
+func (q *QueryHandler) renderTemplates(telegramUserID int64, payload UserPayload) {
+	msg := &q.EventData.Message
+	if !isMessageTemplate(*msg) {
+		return
+	}
+
+	vars := &templateVars{
+		q:              q,
+		telegramUserID: telegramUserID,
+		payload:        payload,
+		values:         map[string]string{},
+	}
+
+	format := parseMode
+	if msg.Format != "" {
+		format = string(msg.Format)
+	}
+	msg.Text = vars.render(msg.Text, getTemplateEscaper(format))
+
+	// album slice is shared with the script too
+	if len(msg.Album) > 0 {
+		album := make([]AlbumItem, len(msg.Album))
+		for i, item := range msg.Album {
+			item.Caption = vars.render(item.Caption, getTemplateEscaper(format))
+			album[i] = item
+		}
+		msg.Album = album
+	}
+
+	// buttons slice is shared with the script
+	buttons := make([]MessageButton, len(msg.Buttons))
+	for i, btn := range msg.Buttons {
+		// reply button texts are mapped back to events, so they are static
+		if msg.Keyboard != KeyboardReply {
+			btn.Text = vars.render(btn.Text, getTemplateEscaper(""))
+		}
+		btn.URL = vars.render(btn.URL, url.QueryEscape)
+		buttons[i] = btn
+	}
+	msg.Buttons = buttons
+}
+
+func (v *templateVars) render(text string, escape func(string) string) string {
+	if !hasTemplate(text) {
+		return text
+	}
+
+	return templateVarRegexp.ReplaceAllStringFunc(text, func(match string) string {
+		name := templateVarRegexp.FindStringSubmatch(match)[1]
+		return escape(v.get(name))
+	})
+}
+
+func (v *templateVars) get(name string) string {
+	if value, isExists := v.values[name]; isExists {
+		return value
+	}
+
+	value := v.resolve(name)
+	v.values[name] = value
+	return value
+}
+
+func (v *templateVars) resolve(name string) string {
+	if cb, isCustom := v.q.templateVars[name]; isCustom {
+		value, err := cb(v.telegramUserID)
+		if err != nil {
+			v.q.reportError(ErrorStageBuild, fmt.Errorf("get template variable %q: %w", name, err))
+		}
+		return value
+	}
+
+	if strings.HasPrefix(name, sessionTemplatePrefix) {
+		return v.getSessionVar(strings.TrimPrefix(name, sessionTemplatePrefix))
+	}
+
+	switch name {
+	default:
+		v.q.errReporter.getLogger().Warn(
+			"unknown template variable", "name", name, "eventID", v.q.EventMessageID,
+		)
+		return ""
+	case "user_id":
+		return strconv.FormatInt(v.telegramUserID, 10)
+	case "first_name", "last_name", "username":
+		return v.getUserName(name)
+	case "utm_source", "utm_campaign", "utm_content":
+		return v.getUTMTag(name)
+	case "user_input":
+		return v.getUserInput()
+	case "button_param":
+		return v.payload.ButtonParam
+	}
+}
+
+func (v *templateVars) getUserName(name string) string {
+	if sender := v.q.sender; sender != nil {
+		switch name {
+		case "first_name":
+			return sender.FirstName
+		case "last_name":
+			return sender.LastName
+		default:
+			return sender.Username
+		}
+	}
+
+	// event is sent without user update, e.g. by drip or broadcast
+	user := v.getStoredUser()
+	if user == nil {
+		return ""
+	}
+
+	names := strings.SplitN(user.Name, " ", 2)
+	switch name {
+	case "first_name":
+		return names[0]
+	case "last_name":
+		if len(names) < 2 {
+			return ""
+		}
+		return names[1]
+	default:
+		return strings.TrimPrefix(user.TgName, "@")
+	}
+}
+
+func (v *templateVars) getUTMTag(name string) string {
+	var tags UTMTags
+	switch {
+	case v.payload.hasUTM():
+		tags = UTMTags{
+			Source:   v.payload.UTMSource,
+			Campaign: v.payload.UTMCampaign,
+			Content:  v.payload.UTMContent,
+		}
+	case v.q.Features.IsUTMTagsFeatureActive():
+		tags = v.q.Features.UTM.GetUserUTMTags(v.telegramUserID)
+	default:
+		if user := v.getStoredUser(); user != nil {
+			tags = UTMTags{
+				Source:   user.LastTouch.UTMSource,
+				Campaign: user.LastTouch.UTMCampaign,
+				Content:  user.LastTouch.UTMContent,
+			}
+		}
+	}
+
+	switch name {
+	case "utm_source":
+		return tags.Source
+	case "utm_campaign":
+		return tags.Campaign
+	default:
+		return tags.Content
+	}
+}
+
+func (v *templateVars) getUserInput() string {
+	if !v.q.Features.IsUserInputFeatureActive() ||
+		v.q.Features.UserInput.GetUserInputCallback == nil {
+		return ""
+	}
+
+	input, err := v.q.Features.UserInput.GetUserInputCallback(v.telegramUserID)
+	if err != nil {
+		v.q.reportError(ErrorStageBuild, fmt.Errorf("get user input: %w", err))
+	}
+	return input
+}
+
+func (v *templateVars) getSessionVar(key string) string {
+	if !v.q.Features.IsSessionsFeatureActive() {
+		return ""
+	}
+
+	session, err := v.q.Features.Sessions.get(v.telegramUserID)
+	if err != nil {
+		v.q.reportError(ErrorStageSession, fmt.Errorf("load: %w", err))
+		return ""
+	}
+	return session.Get(key)
+}
+
+func (v *templateVars) getStoredUser() *User {
+	if v.storedUser != nil || v.q.Features.Users == nil {
+		return v.storedUser
+	}
+
+	user, err := v.q.Features.Users.Store.GetUser(v.telegramUserID)
+	if err != nil {
+		v.q.reportError(ErrorStageBuild, fmt.Errorf("get user: %w", err))
+	}
+	v.storedUser = user
+	return user
+}
@@ -0,0 +1,93 @@
+package tgfun
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+	tb "gopkg.in/telebot.v3"
+)
+
+func getTemplatesTestFunnel(format ParseFormat) *Funnel {
+	return NewFunnel(FunnelData{}, FunnelScript{
+		"/start": {Message: EventMessage{
+			Text:   "Hi, {{first_name}}! Your code: {{ promo }}",
+			Format: format,
+			Buttons: []MessageButton{
+				{Text: "{{first_name}}, go", NextMessageID: "/start"},
+				{Text: "site", URL: "https://example.com/?name={{first_name}}&src={{utm_source}}"},
+			},
+		}},
+	})
+}
+
+func TestRenderTemplatesMarkdown(t *testing.T) {
+	// given
+	f := getTemplatesTestFunnel(ParseFormatMarkdown)
+	f.RegisterTemplateVariable("promo", func(telegramUserID int64) (string, error) {
+		return "SALE_10", nil
+	})
+	q, err := f.GetEventQueryHandler("/start")
+	require.NoError(t, err)
+	q.sender = &tb.User{ID: 1, FirstName: "Jo*hn"}
+
+	// when
+	q.renderTemplates(1, UserPayload{UTMSource: "ya ds"})
+
+	// then
+	assert.Equal(t, `Hi, Jo\*hn! Your code: SALE\_10`, q.EventData.Message.Text)
+	assert.Equal(t, "Jo*hn, go", q.EventData.Message.Buttons[0].Text)
+	assert.Equal(t, "https://example.com/?name=Jo%2Ahn&src=ya+ds", q.EventData.Message.Buttons[1].URL)
+	assert.Equal(t, "{{first_name}}, go", f.Script["/start"].Message.Buttons[0].Text)
+}
+
+func TestRenderTemplatesHTML(t *testing.T) {
+	// given
+	f := getTemplatesTestFunnel(ParseFormatHTML)
+	q, err := f.GetEventQueryHandler("/start")
+	require.NoError(t, err)
+	q.sender = &tb.User{ID: 1, FirstName: "<b>John</b>"}
+
+	// when
+	q.renderTemplates(1, UserPayload{})
+
+	// then
+	assert.Equal(t, "Hi, &lt;b&gt;John&lt;/b&gt;! Your code: ", q.EventData.Message.Text)
+}
+
+func TestRenderTemplatesAlbumCaptions(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{
+		"/start": {Message: EventMessage{
+			Format: ParseFormatHTML,
+			Album: []AlbumItem{
+				{Image: "1.jpg", Caption: "For {{first_name}}"},
+				{Image: "2.jpg"},
+			},
+		}},
+	})
+	q, err := f.GetEventQueryHandler("/start")
+	require.NoError(t, err)
+	q.sender = &tb.User{ID: 1, FirstName: "<b>John</b>"}
+
+	// when
+	q.renderTemplates(1, UserPayload{})
+
+	// then
+	assert.Equal(t, "For &lt;b&gt;John&lt;/b&gt;", q.EventData.Message.Album[0].Caption)
+	assert.Equal(t, "", q.EventData.Message.Album[1].Caption)
+	assert.Equal(t, "For {{first_name}}", f.Script["/start"].Message.Album[0].Caption)
+}
+
+func TestValidateUnknownTemplateVariable(t *testing.T) {
+	// given
+	f := getTemplatesTestFunnel(ParseFormatMarkdown)
+
+	// when
+	result := f.Validate()
+
+	// then
+	require.Len(t, result.Warnings, 1)
+	assert.Equal(t, "message.text", result.Warnings[0].Field)
+	assert.Contains(t, result.Warnings[0].Message, `"promo"`)
+}
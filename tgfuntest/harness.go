@@ -0,0 +1,41 @@
+// Package tgfuntest provides fake Telegram Bot API server and virtual users
+// to test funnels end-to-end without network
+package tgfuntest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Sagleft/tgfun"
+)
+
+const stopTimeout = 10 * time.Second
+
+// Run funnel against new fake server. Funnel token and API URL are replaced.
+// Enable funnel features before the call. Funnel is stopped and server is
+// closed on test cleanup
+func Run(t testing.TB, f *tgfun.Funnel) *Server {
+	t.Helper()
+
+	s := NewServer()
+	t.Cleanup(s.Close)
+
+	f.Data.Token = s.Token
+	f.Data.APIURL = s.URL
+	f.Data.Webhook = tgfun.WebhookData{} // long polling only
+
+	if err := f.Run(); err != nil {
+		t.Fatalf("run funnel: %s", err.Error())
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		defer cancel()
+
+		if err := f.Stop(ctx); err != nil {
+			t.Errorf("stop funnel: %s", err.Error())
+		}
+	})
+	return s
+}
@@ -0,0 +1,701 @@
+package tgfuntest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sagleft/tgfun"
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+	tb "gopkg.in/telebot.v3"
+)
+
+const lockerChatID = -100500
+
+func getTestScript() tgfun.FunnelScript {
+	return tgfun.FunnelScript{
+		"/start": {Message: tgfun.EventMessage{
+			Text: "hello",
+			Buttons: []tgfun.MessageButton{
+				{Text: "next", NextMessageID: "offer"},
+				{Text: "site", URL: "https://example.com"},
+			},
+		}},
+		"offer": {
+			Message: tgfun.EventMessage{Text: "offer", PinThisMessage: true},
+			SubscriptionLocker: tgfun.EventLocker{
+				Enabled:         true,
+				ChatID:          lockerChatID,
+				LockerMessageID: "subscribe",
+			},
+		},
+		"subscribe": {Message: tgfun.EventMessage{
+			Text:    "subscribe first",
+			Buttons: []tgfun.MessageButton{{Text: "done", NextMessageID: "offer"}},
+		}},
+	}
+}
+
+func TestStartAndPressButton(t *testing.T) {
+	// given
+	f := tgfun.NewFunnel(tgfun.FunnelData{}, getTestScript())
+	s := Run(t, f)
+	user := s.NewUser(1001, "John")
+
+	// when
+	user.Start("")
+	start, errStart := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errStart)
+
+	callbackID, errPress := user.Press("next")
+	require.NoError(t, errPress)
+	locker, errLocker := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errLocker)
+
+	// then
+	assert.Equal(t, "hello", start.Text)
+	_, isURLButton := start.Button("site")
+	assert.True(t, isURLButton)
+	assert.Equal(t, "subscribe first", locker.Text)
+	assert.NoError(t, user.WaitCallbackAnswer(callbackID, DefaultWaitTimeout))
+}
+
+func TestSubscriptionLockerPassed(t *testing.T) {
+	// given
+	f := tgfun.NewFunnel(tgfun.FunnelData{}, getTestScript())
+	s := Run(t, f)
+	user := s.NewUser(1002, "Jane")
+	s.SetChatMember(lockerChatID, user.ID, tb.Member)
+
+	// when
+	user.Send("offer")
+	msg, err := user.WaitMessage(DefaultWaitTimeout)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "offer", msg.Text)
+}
+
+func TestUnknownTextIsIgnored(t *testing.T) {
+	// given
+	f := tgfun.NewFunnel(tgfun.FunnelData{}, getTestScript())
+	s := Run(t, f)
+	user := s.NewUser(1003, "Bob")
+
+	// when
+	user.Send("hi there")
+	_, err := user.WaitMessage(100 * time.Millisecond)
+
+	// then
+	assert.Error(t, err)
+}
+
+func getNavigationTestScript() tgfun.FunnelScript {
+	return tgfun.FunnelScript{
+		"/start": {Message: tgfun.EventMessage{
+			Text: "hello",
+			Buttons: []tgfun.MessageButton{
+				{Text: "text", NextMessageID: "about"},
+				{Text: "photo", NextMessageID: "photo"},
+			},
+		}},
+		"about": {Message: tgfun.EventMessage{
+			Text:    "about",
+			Buttons: []tgfun.MessageButton{{Text: "back", NextMessageID: "photo"}},
+		}},
+		"photo": {Message: tgfun.EventMessage{
+			Text:  "photo",
+			Image: "https://example.com/photo.png",
+		}},
+	}
+}
+
+func TestEditNavigation(t *testing.T) {
+	// given
+	f := tgfun.NewFunnel(tgfun.FunnelData{
+		Navigation: tgfun.NavigationEdit,
+	}, getNavigationTestScript())
+	s := Run(t, f)
+	user := s.NewUser(1004, "Alice")
+
+	user.Start("")
+	_, err := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, err)
+
+	// when
+	callbackID, err := user.Press("text")
+	require.NoError(t, err)
+	require.NoError(t, user.WaitCallbackAnswer(callbackID, DefaultWaitTimeout))
+
+	// then
+	messages := user.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "about", messages[0].Text)
+	_, isOldButtonFound := messages[0].Button("text")
+	assert.False(t, isOldButtonFound)
+	_, isNewButtonFound := messages[0].Button("back")
+	assert.True(t, isNewButtonFound)
+}
+
+func TestEditNavigationFallbackToSend(t *testing.T) {
+	// given
+	f := tgfun.NewFunnel(tgfun.FunnelData{
+		Navigation: tgfun.NavigationEdit,
+	}, getNavigationTestScript())
+	s := Run(t, f)
+	user := s.NewUser(1005, "Carol")
+
+	user.Start("")
+	_, err := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, err)
+
+	// when
+	_, err = user.Press("photo")
+	require.NoError(t, err)
+	msg, err := user.WaitMessage(DefaultWaitTimeout)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "sendPhoto", msg.Method)
+	assert.Equal(t, "photo", msg.Text)
+	assert.Len(t, user.Messages(), 2)
+}
+
+func TestButtonParam(t *testing.T) {
+	// given
+	longParam := strings.Repeat("sku-", 20)
+	params := make(chan string, 2)
+	f := tgfun.NewFunnel(tgfun.FunnelData{}, tgfun.FunnelScript{
+		"/start": {Message: tgfun.EventMessage{
+			Text: "catalog",
+			Buttons: []tgfun.MessageButton{
+				{Text: "short", NextMessageID: "product", Param: "42"},
+				{Text: "long", NextMessageID: "product", Param: longParam},
+			},
+		}},
+		"product": {Message: tgfun.EventMessage{
+			CallbackWithParam: func(telegramUserID int64, buttonParam string) interface{} {
+				return "product " + buttonParam
+			},
+			OnEvent: func(ctx tb.Context) error {
+				params <- tgfun.ButtonParamFromContext(ctx)
+				return nil
+			},
+		}},
+	})
+	s := Run(t, f)
+	user := s.NewUser(1006, "Dave")
+
+	user.Start("")
+	_, err := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, err)
+
+	// when
+	_, err = user.Press("short")
+	require.NoError(t, err)
+	short, errShort := user.WaitMessage(DefaultWaitTimeout)
+
+	_, err = user.Press("long")
+	require.NoError(t, err)
+	long, errLong := user.WaitMessage(DefaultWaitTimeout)
+
+	// then
+	require.NoError(t, errShort)
+	require.NoError(t, errLong)
+	assert.Equal(t, "product 42", short.Text)
+	assert.Equal(t, "product "+longParam, long.Text)
+	assert.Equal(t, "42", <-params)
+	assert.Equal(t, longParam, <-params)
+}
+
+func TestReplyKeyboard(t *testing.T) {
+	// given
+	contacts := make(chan string, 1)
+	f := tgfun.NewFunnel(tgfun.FunnelData{}, tgfun.FunnelScript{
+		"/start": {Message: tgfun.EventMessage{
+			Text:     "menu",
+			Keyboard: tgfun.KeyboardReply,
+			Buttons: []tgfun.MessageButton{
+				{Text: "Catalog", NextMessageID: "catalog"},
+				{Text: "Share phone", NextMessageID: "thanks", RequestContact: true},
+			},
+		}},
+		"catalog": {Message: tgfun.EventMessage{Text: "catalog"}},
+		"thanks": {Message: tgfun.EventMessage{
+			Text:     "thanks",
+			Keyboard: tgfun.KeyboardRemove,
+			OnEvent: func(ctx tb.Context) error {
+				contacts <- ctx.Message().Contact.PhoneNumber
+				return nil
+			},
+		}},
+	})
+	s := Run(t, f)
+	user := s.NewUser(1007, "Eve")
+	user.Phone = "+10000000000"
+
+	user.Start("")
+	_, err := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, err)
+
+	// when
+	require.NoError(t, user.PressReply("Catalog"))
+	catalog, errCatalog := user.WaitMessage(DefaultWaitTimeout)
+
+	require.NoError(t, user.PressReply("Share phone"))
+	thanks, errThanks := user.WaitMessage(DefaultWaitTimeout)
+
+	// then
+	require.NoError(t, errCatalog)
+	require.NoError(t, errThanks)
+	assert.Equal(t, "catalog", catalog.Text)
+	assert.Equal(t, "thanks", thanks.Text)
+	assert.Equal(t, user.Phone, <-contacts)
+	assert.Nil(t, user.ReplyKeyboard())
+}
+
+func TestLocalizedFunnel(t *testing.T) {
+	// given
+	f := tgfun.NewFunnel(tgfun.FunnelData{
+		Localization: tgfun.LocalizationData{
+			DefaultLanguage: "en",
+			Languages:       []string{"en", "ru"},
+		},
+	}, tgfun.FunnelScript{
+		"/start": {Message: tgfun.EventMessage{
+			Text:         "hello, {{first_name}}",
+			Translations: map[string]string{"ru": "привет, {{first_name}}"},
+		}},
+	})
+	s := Run(t, f)
+	user := s.NewUser(1008, "Ivan")
+	user.LanguageCode = "ru"
+
+	// when
+	user.Start("")
+	msg, err := user.WaitMessage(DefaultWaitTimeout)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "привет, Ivan", msg.Text)
+}
+
+func TestAlbumWithButtons(t *testing.T) {
+	// given
+	root := t.TempDir()
+	for _, name := range []string{"1.jpg", "2.mp4"} {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(name), 0o644))
+	}
+
+	gallery := tgfun.FunnelEvent{Message: tgfun.EventMessage{
+		Text: "choose",
+		Album: []tgfun.AlbumItem{
+			{Image: "1.jpg", Caption: "first"},
+			{Video: "2.mp4"},
+		},
+		Buttons: []tgfun.MessageButton{{Text: "again", NextMessageID: "gallery"}},
+	}}
+	f := tgfun.NewFunnel(tgfun.FunnelData{
+		ImageRoot:          root,
+		ResourcesCachePath: filepath.Join(root, "cache.json"),
+	}, tgfun.FunnelScript{"/start": gallery, "gallery": gallery})
+	s := Run(t, f)
+	user := s.NewUser(1009, "Ann")
+
+	// when
+	user.Start("")
+	var messages []Message
+	for i := 0; i < 3; i++ {
+		msg, err := user.WaitMessage(DefaultWaitTimeout)
+		require.NoError(t, err)
+		messages = append(messages, msg)
+	}
+
+	_, errPress := user.Press("again")
+	require.NoError(t, errPress)
+	photo, errPhoto := user.WaitMessage(DefaultWaitTimeout)
+	video, errVideo := user.WaitMessage(DefaultWaitTimeout)
+
+	// then
+	assert.Equal(t, "sendPhoto", messages[0].Method)
+	assert.Equal(t, "first", messages[0].Text)
+	assert.True(t, messages[0].Uploaded)
+	assert.Equal(t, "sendVideo", messages[1].Method)
+	assert.Equal(t, messages[0].AlbumID, messages[1].AlbumID)
+	assert.Equal(t, "choose", messages[2].Text)
+	_, hasButton := messages[2].Button("again")
+	assert.True(t, hasButton)
+
+	// album items are sent by cached file IDs
+	require.NoError(t, errPhoto)
+	require.NoError(t, errVideo)
+	assert.False(t, photo.Uploaded)
+	assert.Equal(t, messages[0].FileID, photo.FileID)
+	assert.False(t, video.Uploaded)
+	assert.Equal(t, messages[1].FileID, video.FileID)
+}
+
+func TestVoiceAndStickerMessages(t *testing.T) {
+	// given
+	root := t.TempDir()
+	for _, name := range []string{"hello.ogg", "hi.webp"} {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(name), 0o644))
+	}
+
+	f := tgfun.NewFunnel(tgfun.FunnelData{
+		ImageRoot:          root,
+		ResourcesCachePath: filepath.Join(root, "cache.json"),
+	}, tgfun.FunnelScript{
+		"/start": {Message: tgfun.EventMessage{
+			Text:    "listen",
+			Voice:   tgfun.VoiceData{Path: "hello.ogg", Duration: 3},
+			Buttons: []tgfun.MessageButton{{Text: "next", NextMessageID: "sticker"}},
+		}},
+		"sticker": {Message: tgfun.EventMessage{
+			Sticker: tgfun.StickerData{Path: "hi.webp"},
+			Buttons: []tgfun.MessageButton{{Text: "again", NextMessageID: "sticker"}},
+		}},
+	})
+	s := Run(t, f)
+	user := s.NewUser(1010, "Max")
+
+	// when
+	user.Start("")
+	voice, errVoice := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errVoice)
+
+	_, errPress := user.Press("next")
+	require.NoError(t, errPress)
+	sticker, errSticker := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errSticker)
+
+	_, errAgain := user.Press("again")
+	require.NoError(t, errAgain)
+	cached, errCached := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errCached)
+
+	// then
+	assert.Equal(t, "sendVoice", voice.Method)
+	assert.Equal(t, "listen", voice.Text)
+	assert.True(t, voice.Uploaded)
+	assert.Equal(t, "sendSticker", sticker.Method)
+	assert.True(t, sticker.Uploaded)
+	assert.False(t, cached.Uploaded)
+	assert.Equal(t, sticker.FileID, cached.FileID)
+}
+
+func TestVideoIsCached(t *testing.T) {
+	// given
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "intro.mp4"), []byte("video"), 0o644))
+
+	f := tgfun.NewFunnel(tgfun.FunnelData{
+		ImageRoot:          root,
+		ResourcesCachePath: filepath.Join(root, "cache.json"),
+	}, tgfun.FunnelScript{
+		"/start": {Message: tgfun.EventMessage{
+			Text:  "intro",
+			Video: tgfun.VideoData{Path: "intro.mp4"},
+		}},
+	})
+	s := Run(t, f)
+	first, second := s.NewUser(1011, "Kate"), s.NewUser(1012, "Leo")
+
+	// when
+	first.Start("")
+	uploaded, errUploaded := first.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errUploaded)
+
+	second.Start("")
+	cached, errCached := second.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errCached)
+
+	// then
+	assert.Equal(t, "sendVideo", uploaded.Method)
+	assert.True(t, uploaded.Uploaded)
+	assert.False(t, cached.Uploaded)
+	assert.Equal(t, uploaded.FileID, cached.FileID)
+}
+
+func TestCacheWarmUp(t *testing.T) {
+	// given
+	const serviceChatID = -100777
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "logo.png"), []byte("logo"), 0o644))
+
+	f := tgfun.NewFunnel(tgfun.FunnelData{
+		ImageRoot:   root,
+		CacheWarmUp: tgfun.CacheWarmUpData{ChatID: serviceChatID},
+	}, tgfun.FunnelScript{
+		"/start": {Message: tgfun.EventMessage{Text: "hello", Image: "logo.png"}},
+	})
+	f.SetResourceStore(tgfun.NewMemoryResourceStore())
+	s := Run(t, f)
+	user := s.NewUser(1013, "Mia")
+
+	// when
+	upload, errUpload := s.WaitRequest("sendPhoto", DefaultWaitTimeout)
+	require.NoError(t, errUpload)
+	_, errDelete := s.WaitRequest("deleteMessage", DefaultWaitTimeout)
+	require.NoError(t, errDelete)
+
+	user.Start("")
+	msg, err := user.WaitMessage(DefaultWaitTimeout)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(serviceChatID), upload.Params["chat_id"])
+	assert.False(t, msg.Uploaded)
+	assert.NotEmpty(t, msg.FileID)
+}
+
+func TestReloadScript(t *testing.T) {
+	// given
+	f := tgfun.NewFunnel(tgfun.FunnelData{}, getTestScript())
+	s := Run(t, f)
+	user := s.NewUser(1014, "Leo")
+
+	user.Start("")
+	_, errStart := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errStart)
+
+	script := getTestScript()
+	script["/start"] = tgfun.FunnelEvent{Message: tgfun.EventMessage{Text: "welcome"}}
+	script["offer"] = tgfun.FunnelEvent{Message: tgfun.EventMessage{Text: "new offer"}}
+	delete(script, "subscribe")
+
+	// when
+	require.NoError(t, f.ReloadScript(script))
+
+	_, errPress := user.Press("next") // button of the message sent before reload
+	require.NoError(t, errPress)
+	offer, errOffer := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errOffer)
+
+	user.Start("")
+	start, errReloaded := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errReloaded)
+
+	// then
+	assert.Equal(t, "new offer", offer.Text)
+	assert.Equal(t, "welcome", start.Text)
+}
+
+func TestOnErrorMissingFile(t *testing.T) {
+	// given
+	root := t.TempDir()
+	imagePath := filepath.Join(root, "logo.png")
+	require.NoError(t, os.WriteFile(imagePath, []byte("logo"), 0o644))
+
+	f := tgfun.NewFunnel(tgfun.FunnelData{ImageRoot: root}, tgfun.FunnelScript{
+		"/start": {Message: tgfun.EventMessage{
+			Text:    "hello",
+			Buttons: []tgfun.MessageButton{{Text: "next", NextMessageID: "photo"}},
+		}},
+		"photo": {Message: tgfun.EventMessage{Text: "photo", Image: "logo.png"}},
+	})
+	f.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	errs := make(chan tgfun.ErrorEvent, 1)
+	f.OnError(func(e tgfun.ErrorEvent) {
+		errs <- e
+	})
+	s := Run(t, f)
+	user := s.NewUser(1015, "Ada")
+	require.NoError(t, os.Remove(imagePath))
+
+	// when
+	user.Send("photo")
+	msg, err := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, err)
+
+	// then
+	assert.Equal(t, "photo", msg.Text)
+	select {
+	case e := <-errs:
+		assert.Equal(t, tgfun.ErrorStageBuild, e.Stage)
+		assert.Equal(t, user.ID, e.UserID)
+		assert.Equal(t, "photo", e.EventID)
+	case <-time.After(DefaultWaitTimeout):
+		t.Fatal("error is not reported")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	// given
+	script := getTestScript()
+	offer := script["offer"]
+	offer.Message.Conversion = "offer_view"
+	script["offer"] = offer
+
+	f := tgfun.NewFunnel(tgfun.FunnelData{}, script)
+	require.NoError(t, f.EnableMetricsFeature(tgfun.MetricsFeature{}))
+	s := Run(t, f)
+	user := s.NewUser(1016, "Max")
+	s.SetChatMember(lockerChatID, user.ID, tb.Member)
+
+	// when
+	user.Start("")
+	_, errStart := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errStart)
+
+	callbackID, errPress := user.Press("next")
+	require.NoError(t, errPress)
+	_, errOffer := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errOffer)
+	require.NoError(t, user.WaitCallbackAnswer(callbackID, DefaultWaitTimeout))
+
+	w := httptest.NewRecorder()
+	f.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, w.Code)
+	output := w.Body.String()
+	assert.Contains(t, output, `tgfun_event_deliveries_total{event="/start"} 1`)
+	assert.Contains(t, output, `tgfun_button_clicks_total{event="offer",button="next"} 1`)
+	assert.Contains(t, output, `tgfun_conversions_total{conversion="offer_view"} 1`)
+	assert.Contains(t, output, `tgfun_locker_checks_total{result="passed"} 1`)
+	assert.Contains(t, output, `tgfun_messages_sent_total{type="text",result="ok"} 2`)
+}
+
+func TestMetricsLockedConversion(t *testing.T) {
+	// given
+	script := getTestScript()
+	offer := script["offer"]
+	offer.Message.Conversion = "offer_view"
+	script["offer"] = offer
+
+	f := tgfun.NewFunnel(tgfun.FunnelData{}, script)
+	require.NoError(t, f.EnableMetricsFeature(tgfun.MetricsFeature{}))
+	s := Run(t, f)
+	user := s.NewUser(1017, "Max")
+
+	// when
+	user.Start("")
+	_, errStart := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errStart)
+
+	callbackID, errPress := user.Press("next")
+	require.NoError(t, errPress)
+	locker, errLocker := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errLocker)
+	require.NoError(t, user.WaitCallbackAnswer(callbackID, DefaultWaitTimeout))
+
+	w := httptest.NewRecorder()
+	f.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// then
+	assert.Equal(t, "subscribe first", locker.Text)
+	output := w.Body.String()
+	assert.Contains(t, output, `tgfun_event_deliveries_total{event="subscribe"} 1`)
+	assert.NotContains(t, output, `conversion="offer_view"`)
+}
+
+func TestFunnelReport(t *testing.T) {
+	// given
+	script := getTestScript()
+	offer := script["offer"]
+	offer.Message.Conversion = "offer_view"
+	offer.SubscriptionLocker = tgfun.EventLocker{}
+	script["offer"] = offer
+
+	f := tgfun.NewFunnel(tgfun.FunnelData{}, script)
+	store := tgfun.NewMemoryAnalyticsStore()
+	require.NoError(t, f.EnableAnalyticsFeature(tgfun.AnalyticsFeature{Store: store}))
+	s := Run(t, f)
+	user := s.NewUser(1017, "Eva")
+
+	// when
+	user.Start("ads_spring")
+	_, errStart := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errStart)
+
+	callbackID, errPress := user.Press("next")
+	require.NoError(t, errPress)
+	_, errOffer := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errOffer)
+	require.NoError(t, user.WaitCallbackAnswer(callbackID, DefaultWaitTimeout))
+
+	report, err := f.GetFunnelReport(tgfun.FunnelReportOptions{})
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, report.Cohorts, 1)
+	cohort := report.Cohorts[0]
+	assert.Equal(t, "ads", cohort.UTMSource)
+	assert.Equal(t, "spring", cohort.UTMCampaign)
+	assert.Equal(t, 1, cohort.Users)
+	require.Len(t, cohort.Steps, 1)
+	assert.Equal(t, "offer_view", cohort.Steps[0].Conversion)
+	assert.Equal(t, 1, cohort.Steps[0].Users)
+
+	events, errList := store.ListAnalyticsEvents(time.Time{}, time.Time{})
+	require.NoError(t, errList)
+	var clicks []tgfun.AnalyticsEvent
+	for _, event := range events {
+		if event.Kind == tgfun.AnalyticsEventClick {
+			clicks = append(clicks, event)
+		}
+	}
+	require.Len(t, clicks, 1)
+	assert.Equal(t, "offer", clicks[0].EventID)
+	assert.Equal(t, "next", clicks[0].Button)
+}
+
+func TestWebhookModeStop(t *testing.T) {
+	// given
+	s := NewServer()
+	t.Cleanup(s.Close)
+
+	f := tgfun.NewFunnel(tgfun.FunnelData{
+		Token:   s.Token,
+		APIURL:  s.URL,
+		Webhook: tgfun.WebhookData{PublicURL: "https://example.com/bot"},
+	}, getTestScript())
+	require.NoError(t, f.Run())
+	user := s.NewUser(1018, "Ivy")
+	postUpdate := func() int {
+		body, err := json.Marshal(tb.Update{ID: 1, Message: &tb.Message{
+			Sender:   user.sender(),
+			Chat:     user.chat(),
+			Text:     "/start",
+			Entities: tb.Entities{{Type: tb.EntityCommand, Length: len("/start")}},
+		}})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		f.WebhookHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/bot", bytes.NewReader(body)))
+		return w.Code
+	}
+
+	// when
+	// poller is active after setWebhook response
+	codeRunning := postUpdate()
+	for i := 0; codeRunning == http.StatusServiceUnavailable && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		codeRunning = postUpdate()
+	}
+	msg, errMsg := user.WaitMessage(DefaultWaitTimeout)
+	require.NoError(t, errMsg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	errStop := f.Stop(ctx)
+	codeStopped := postUpdate()
+
+	// then
+	assert.Equal(t, http.StatusOK, codeRunning)
+	assert.Equal(t, "hello", msg.Text)
+	assert.NoError(t, errStop)
+	assert.Equal(t, http.StatusServiceUnavailable, codeStopped)
+}
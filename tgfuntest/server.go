@@ -0,0 +1,794 @@
+package tgfuntest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	defaultToken = "123456:fake-token"
+	botID        = 123456
+	botUsername  = "tgfun_test_bot"
+	// telebot may start poll request during Stop without cancellation,
+	// so getUpdates wait is short to keep funnel stop fast
+	maxPollDuration = 250 * time.Millisecond
+	maxFileSize     = 32 << 20
+)
+
+// media field of the message returned by send method
+var mediaMethods = map[string]string{
+	"sendPhoto":     "photo",
+	"sendDocument":  "document",
+	"sendVideo":     "video",
+	"sendAudio":     "audio",
+	"sendVoice":     "voice",
+	"sendVideoNote": "video_note",
+	"sendAnimation": "animation",
+	"sendSticker":   "sticker",
+}
+
+// Message - message sent by bot
+type Message struct {
+	ID       int
+	ChatID   int64
+	Method   string     // API method, e.g. sendMessage or sendPhoto
+	Text     string     // text or media caption
+	FileID   string     // sent media file ID
+	Uploaded bool       // media was uploaded from disk, not sent by file ID or URL
+	Buttons  [][]Button // inline keyboard
+	IsPinned bool
+	Params   map[string]string // raw request params
+	AlbumID  string            // media group ID, set for album items
+
+	ReplyKeyboard   [][]Button
+	RemoveKeyboard  bool // reply keyboard is removed
+	OneTimeKeyboard bool
+}
+
+// Button - inline or reply keyboard button
+type Button struct {
+	Text            string
+	Data            string // callback data
+	URL             string
+	RequestContact  bool
+	RequestLocation bool
+}
+
+// ReplyButton returns reply keyboard button with given text
+func (m Message) ReplyButton(text string) (Button, bool) {
+	for _, row := range m.ReplyKeyboard {
+		for _, btn := range row {
+			if btn.Text == text {
+				return btn, true
+			}
+		}
+	}
+	return Button{}, false
+}
+
+// Button returns inline button with given text
+func (m Message) Button(text string) (Button, bool) {
+	for _, row := range m.Buttons {
+		for _, btn := range row {
+			if btn.Text == text {
+				return btn, true
+			}
+		}
+	}
+	return Button{}, false
+}
+
+// Request - Bot API request received by server
+type Request struct {
+	Method string
+	Params map[string]string
+	Files  []string // uploaded multipart fields
+}
+
+// Server - in-process fake Telegram Bot API server
+type Server struct {
+	Token string
+	URL   string
+
+	http *httptest.Server
+
+	locker        sync.Mutex
+	changed       chan struct{} // closed and replaced on every state change
+	closed        chan struct{}
+	lastUpdateID  int
+	updates       []tb.Update
+	lastMessageID int
+	lastFileID    int
+	lastAlbumID   int
+	messages      []Message
+	requests      []Request
+	members       map[string]tb.MemberStatus // chat ID:user ID -> role
+	callbacks     map[string]string          // callback ID -> answer text
+}
+
+// NewServer starts fake Bot API server. Close it when done
+func NewServer() *Server {
+	s := &Server{
+		Token:     defaultToken,
+		changed:   make(chan struct{}),
+		closed:    make(chan struct{}),
+		members:   map[string]tb.MemberStatus{},
+		callbacks: map[string]string{},
+	}
+	s.http = httptest.NewServer(s)
+	s.URL = s.http.URL
+	return s
+}
+
+// Close server. Pending getUpdates requests are finished
+func (s *Server) Close() {
+	s.locker.Lock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	s.locker.Unlock()
+
+	s.http.Close()
+}
+
+// SetChatMember sets user role returned by getChatMember. Default: left
+func (s *Server) SetChatMember(chatID, telegramUserID int64, role tb.MemberStatus) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.members[memberKey(chatID, telegramUserID)] = role
+}
+
+// Messages returns all messages sent by bot
+func (s *Server) Messages() []Message {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	return append([]Message{}, s.messages...)
+}
+
+// Requests returns all received API requests, including getUpdates
+func (s *Server) Requests() []Request {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	return append([]Request{}, s.requests...)
+}
+
+// WaitRequest waits for API request with given method
+func (s *Server) WaitRequest(method string, timeout time.Duration) (Request, error) {
+	var req Request
+	isReceived := s.waitFor(timeout, func() bool {
+		for _, r := range s.requests {
+			if r.Method == method {
+				req = r
+				return true
+			}
+		}
+		return false
+	})
+	if !isReceived {
+		return Request{}, fmt.Errorf("no %s request in %s", method, timeout)
+	}
+	return req, nil
+}
+
+// CallbackAnswer returns answerCallbackQuery text for callback
+func (s *Server) CallbackAnswer(callbackID string) (string, bool) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	text, isAnswered := s.callbacks[callbackID]
+	return text, isAnswered
+}
+
+func memberKey(chatID, telegramUserID int64) string {
+	return fmt.Sprintf("%v:%v", chatID, telegramUserID)
+}
+
+// must be called under lock
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) pushUpdate(update tb.Update) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.lastUpdateID++
+	update.ID = s.lastUpdateID
+	s.updates = append(s.updates, update)
+	s.notify()
+}
+
+// wait until cond returns true. cond is called under lock
+func (s *Server) waitFor(timeout time.Duration, cond func() bool) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.locker.Lock()
+		if cond() {
+			s.locker.Unlock()
+			return true
+		}
+		changed := s.changed
+		s.locker.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		case <-s.closed:
+			return false
+		}
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, method, isValid := parsePath(r.URL.Path)
+	if !isValid {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if token != s.Token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	req, err := parseRequest(method, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	s.locker.Lock()
+	s.requests = append(s.requests, req)
+	s.notify()
+	s.locker.Unlock()
+
+	result, err := s.handle(r, req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	if result == nil {
+		writeError(w, http.StatusNotFound, "Not Found: method not found")
+		return
+	}
+	writeResult(w, result)
+}
+
+// returns nil result for unknown methods
+func (s *Server) handle(r *http.Request, req Request) (interface{}, error) {
+	switch req.Method {
+	case "getMe":
+		return tb.User{ID: botID, IsBot: true, FirstName: "tgfun", Username: botUsername}, nil
+	case "getUpdates":
+		return s.getUpdates(r, req.Params)
+	case "setWebhook", "deleteWebhook", "sendChatAction":
+		return true, nil
+	case "sendMessage":
+		return s.sendMessage(req, "", false)
+	case "sendMediaGroup":
+		return s.sendMediaGroup(req)
+	case "answerCallbackQuery":
+		return s.answerCallback(req.Params)
+	case "getChatMember":
+		return s.getChatMember(req.Params)
+	case "pinChatMessage":
+		return s.pinMessage(req.Params)
+	case "editMessageText", "editMessageCaption", "editMessageReplyMarkup", "editMessageMedia":
+		return s.editMessage(req)
+	case "deleteMessage":
+		return s.deleteMessage(req.Params)
+	}
+
+	if field, isMedia := mediaMethods[req.Method]; isMedia {
+		fileID, isUploaded := s.getFileID(req, field)
+		return s.sendMessage(req, fileID, isUploaded)
+	}
+	return nil, nil
+}
+
+func (s *Server) getUpdates(r *http.Request, params map[string]string) ([]tb.Update, error) {
+	offset, _ := strconv.Atoi(params["offset"])
+	timeout, _ := strconv.Atoi(params["timeout"])
+	wait := time.Duration(timeout) * time.Second
+	if wait > maxPollDuration {
+		wait = maxPollDuration
+	}
+
+	s.locker.Lock()
+	// confirmed updates are removed, like in Bot API
+	for len(s.updates) > 0 && s.updates[0].ID < offset {
+		s.updates = s.updates[1:]
+	}
+	s.locker.Unlock()
+
+	var updates []tb.Update
+	ctx := r.Context()
+	isReady := func() bool {
+		if ctx.Err() != nil {
+			return true
+		}
+		updates = append([]tb.Update{}, s.updates...)
+		return len(updates) > 0
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// wake up waiting when client cancels the request
+		select {
+		case <-ctx.Done():
+			s.locker.Lock()
+			s.notify()
+			s.locker.Unlock()
+		case <-done:
+		}
+	}()
+
+	s.waitFor(wait, isReady)
+	if updates == nil {
+		updates = []tb.Update{}
+	}
+	return updates, nil
+}
+
+func (s *Server) sendMessage(req Request, fileID string, isUploaded bool) (*tb.Message, error) {
+	chatID, err := strconv.ParseInt(req.Params["chat_id"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chat_id: %w", err)
+	}
+
+	markup, err := parseMarkup(req.Params["reply_markup"])
+	if err != nil {
+		return nil, err
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.lastMessageID++
+	msg := Message{
+		ID:       s.lastMessageID,
+		ChatID:   chatID,
+		Method:   req.Method,
+		Text:     req.Params["text"],
+		FileID:   fileID,
+		Uploaded: isUploaded,
+		Params:   req.Params,
+	}
+	markup.apply(&msg)
+	if msg.Text == "" {
+		msg.Text = req.Params["caption"]
+	}
+	s.messages = append(s.messages, msg)
+	s.notify()
+
+	return msg.toTelebot(), nil
+}
+
+// album items are stored as separate messages with the same album ID
+func (s *Server) sendMediaGroup(req Request) ([]*tb.Message, error) {
+	chatID, err := strconv.ParseInt(req.Params["chat_id"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chat_id: %w", err)
+	}
+	if req.Params["reply_markup"] != "" {
+		return nil, fmt.Errorf("media group can't have reply markup")
+	}
+
+	var media []tb.InputMedia
+	if err := json.Unmarshal([]byte(req.Params["media"]), &media); err != nil {
+		return nil, fmt.Errorf("invalid media: %w", err)
+	}
+	if len(media) < 2 || len(media) > 10 {
+		return nil, fmt.Errorf("media group must include 2-10 items")
+	}
+
+	items := make([]Message, 0, len(media))
+	for _, item := range media {
+		method := getMediaMethod(item.Type)
+		if method == "" {
+			return nil, fmt.Errorf("unknown media type %q", item.Type)
+		}
+
+		fileID, isUploaded := s.getMediaFileID(req, item)
+		items = append(items, Message{
+			ChatID:   chatID,
+			Method:   method,
+			Text:     item.Caption,
+			FileID:   fileID,
+			Uploaded: isUploaded,
+			Params:   req.Params,
+		})
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.lastAlbumID++
+	var result []*tb.Message
+	for _, msg := range items {
+		s.lastMessageID++
+		msg.ID = s.lastMessageID
+		msg.AlbumID = strconv.Itoa(s.lastAlbumID)
+		s.messages = append(s.messages, msg)
+		result = append(result, msg.toTelebot())
+	}
+	s.notify()
+	return result, nil
+}
+
+// returns file ID and is file uploaded
+func (s *Server) getFileID(req Request, field string) (string, bool) {
+	for _, uploaded := range req.Files {
+		if uploaded == field {
+			s.locker.Lock()
+			defer s.locker.Unlock()
+
+			s.lastFileID++
+			return fmt.Sprintf("file-%v", s.lastFileID), true
+		}
+	}
+	return req.Params[field], false // file ID or URL
+}
+
+// returns input media file ID and is file uploaded
+func (s *Server) getMediaFileID(req Request, media tb.InputMedia) (string, bool) {
+	if field, isAttached := strings.CutPrefix(media.Media, "attach://"); isAttached {
+		return s.getFileID(req, field)
+	}
+	return media.Media, false // file ID or URL
+}
+
+func (s *Server) answerCallback(params map[string]string) (bool, error) {
+	callbackID := params["callback_query_id"]
+	if callbackID == "" {
+		return false, fmt.Errorf("callback_query_id is not set")
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.callbacks[callbackID] = params["text"]
+	s.notify()
+	return true, nil
+}
+
+func (s *Server) getChatMember(params map[string]string) (*tb.ChatMember, error) {
+	chatID, err := strconv.ParseInt(params["chat_id"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chat_id: %w", err)
+	}
+	userID, err := strconv.ParseInt(params["user_id"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user_id: %w", err)
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	role, isExists := s.members[memberKey(chatID, userID)]
+	if !isExists {
+		role = tb.Left
+	}
+	return &tb.ChatMember{User: &tb.User{ID: userID}, Role: role}, nil
+}
+
+func (s *Server) pinMessage(params map[string]string) (bool, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	msg, err := s.findMessage(params)
+	if err != nil {
+		return false, err
+	}
+
+	msg.IsPinned = true
+	s.notify()
+	return true, nil
+}
+
+func (s *Server) editMessage(req Request) (*tb.Message, error) {
+	var media tb.InputMedia
+	var fileID string
+	var isUploaded bool
+	if req.Method == "editMessageMedia" {
+		if err := json.Unmarshal([]byte(req.Params["media"]), &media); err != nil {
+			return nil, fmt.Errorf("invalid media: %w", err)
+		}
+		fileID, isUploaded = s.getMediaFileID(req, media)
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	msg, err := s.findMessage(req.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	isMedia := msg.FileID != ""
+	switch req.Method {
+	case "editMessageText":
+		if isMedia {
+			return nil, fmt.Errorf("there is no text in the message to edit")
+		}
+		msg.Text = req.Params["text"]
+	case "editMessageCaption":
+		if !isMedia {
+			return nil, fmt.Errorf("there is no caption in the message to edit")
+		}
+		msg.Text = req.Params["caption"]
+	case "editMessageMedia":
+		method := getMediaMethod(media.Type)
+		if !isMedia || method == "" {
+			return nil, fmt.Errorf("message can't be edited")
+		}
+		msg.Method = method
+		msg.Text = media.Caption
+		msg.FileID = fileID
+		msg.Uploaded = isUploaded
+	}
+	// like in Bot API, inline keyboard is removed when markup is not set
+	markup, err := parseMarkup(req.Params["reply_markup"])
+	if err != nil {
+		return nil, err
+	}
+	if markup.isReply() {
+		return nil, fmt.Errorf("only inline keyboard can be edited")
+	}
+	msg.Buttons = markup.inline
+
+	s.notify()
+	return msg.toTelebot(), nil
+}
+
+func (s *Server) deleteMessage(params map[string]string) (bool, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	msg, err := s.findMessage(params)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range s.messages {
+		if s.messages[i].ID == msg.ID {
+			s.messages = append(s.messages[:i], s.messages[i+1:]...)
+			break
+		}
+	}
+	s.notify()
+	return true, nil
+}
+
+// must be called under lock
+func (s *Server) findMessage(params map[string]string) (*Message, error) {
+	chatID, err := strconv.ParseInt(params["chat_id"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chat_id: %w", err)
+	}
+	messageID, err := strconv.Atoi(params["message_id"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid message_id: %w", err)
+	}
+
+	for i := range s.messages {
+		if s.messages[i].ChatID == chatID && s.messages[i].ID == messageID {
+			return &s.messages[i], nil
+		}
+	}
+	return nil, fmt.Errorf("message to edit not found")
+}
+
+// returns empty string for unknown media type
+func getMediaMethod(mediaType string) string {
+	for method, field := range mediaMethods {
+		if field == mediaType {
+			return method
+		}
+	}
+	return ""
+}
+
+func (m Message) toTelebot() *tb.Message {
+	msg := &tb.Message{
+		ID:       m.ID,
+		Chat:     &tb.Chat{ID: m.ChatID, Type: tb.ChatPrivate},
+		Sender:   &tb.User{ID: botID, IsBot: true, Username: botUsername},
+		Unixtime: time.Now().Unix(),
+		AlbumID:  m.AlbumID,
+	}
+
+	file := tb.File{FileID: m.FileID, UniqueID: m.FileID}
+	switch mediaMethods[m.Method] {
+	default:
+		msg.Text = m.Text
+	case "photo":
+		msg.Photo = &tb.Photo{File: file}
+	case "document":
+		msg.Document = &tb.Document{File: file}
+	case "video":
+		msg.Video = &tb.Video{File: file}
+	case "audio":
+		msg.Audio = &tb.Audio{File: file}
+	case "voice":
+		msg.Voice = &tb.Voice{File: file}
+	case "video_note":
+		msg.VideoNote = &tb.VideoNote{File: file}
+	case "animation":
+		msg.Animation = &tb.Animation{File: file}
+	case "sticker":
+		msg.Sticker = &tb.Sticker{File: file}
+	}
+	if msg.Text == "" {
+		msg.Caption = m.Text
+	}
+
+	if len(m.Buttons) > 0 {
+		markup := &tb.ReplyMarkup{}
+		for _, row := range m.Buttons {
+			var btns []tb.InlineButton
+			for _, btn := range row {
+				btns = append(btns, tb.InlineButton{Text: btn.Text, Data: btn.Data, URL: btn.URL})
+			}
+			markup.InlineKeyboard = append(markup.InlineKeyboard, btns)
+		}
+		msg.ReplyMarkup = markup
+	}
+	return msg
+}
+
+type replyMarkup struct {
+	inline  [][]Button
+	reply   [][]Button
+	remove  bool
+	oneTime bool
+}
+
+func (m replyMarkup) isReply() bool {
+	return len(m.reply) > 0 || m.remove
+}
+
+func (m replyMarkup) apply(msg *Message) {
+	msg.Buttons = m.inline
+	msg.ReplyKeyboard = m.reply
+	msg.RemoveKeyboard = m.remove
+	msg.OneTimeKeyboard = m.oneTime
+}
+
+func parseMarkup(rawMarkup string) (replyMarkup, error) {
+	var result replyMarkup
+	if rawMarkup == "" {
+		return result, nil
+	}
+
+	var markup tb.ReplyMarkup
+	if err := json.Unmarshal([]byte(rawMarkup), &markup); err != nil {
+		return result, fmt.Errorf("invalid reply_markup: %w", err)
+	}
+
+	for _, row := range markup.InlineKeyboard {
+		var btns []Button
+		for _, btn := range row {
+			btns = append(btns, Button{Text: btn.Text, Data: btn.Data, URL: btn.URL})
+		}
+		result.inline = append(result.inline, btns)
+	}
+	for _, row := range markup.ReplyKeyboard {
+		var btns []Button
+		for _, btn := range row {
+			btns = append(btns, Button{
+				Text:            btn.Text,
+				RequestContact:  btn.Contact,
+				RequestLocation: btn.Location,
+			})
+		}
+		result.reply = append(result.reply, btns)
+	}
+	result.remove = markup.RemoveKeyboard
+	result.oneTime = markup.OneTimeKeyboard
+	return result, nil
+}
+
+// returns token, method, is path valid. path format: /bot<token>/<method>
+func parsePath(path string) (string, string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "bot") {
+		return "", "", false
+	}
+	return strings.TrimPrefix(parts[0], "bot"), parts[1], true
+}
+
+// telebot sends JSON params or multipart form with files
+func parseRequest(method string, r *http.Request) (Request, error) {
+	req := Request{Method: method, Params: map[string]string{}}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := parseMultipart(r, &req); err != nil {
+			return req, fmt.Errorf("parse multipart form: %w", err)
+		}
+		return req, nil
+	}
+
+	var rawParams map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&rawParams); err != nil {
+		return req, fmt.Errorf("decode params: %w", err)
+	}
+	for key, value := range rawParams {
+		if str, isString := value.(string); isString {
+			req.Params[key] = str
+			continue
+		}
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return req, fmt.Errorf("encode param %q: %w", key, err)
+		}
+		req.Params[key] = string(encoded)
+	}
+	return req, nil
+}
+
+// telebot doesn't set file name for photos and album items,
+// so files are detected by content type as well
+func parseMultipart(r *http.Request, req *Request) error {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, maxFileSize))
+		if err != nil {
+			return fmt.Errorf("read %q: %w", part.FormName(), err)
+		}
+		if part.FileName() != "" || part.Header.Get("Content-Type") == "application/octet-stream" {
+			req.Files = append(req.Files, part.FormName())
+			continue
+		}
+		req.Params[part.FormName()] = string(data)
+	}
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":     true,
+		"result": result,
+	})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":          false,
+		"error_code":  code,
+		"description": description,
+	})
+}
@@ -0,0 +1,218 @@
+package tgfuntest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// DefaultWaitTimeout - default time to wait for bot reply
+const DefaultWaitTimeout = 5 * time.Second
+
+// User - scripted virtual user, that chats with the bot
+type User struct {
+	ID           int64
+	FirstName    string
+	Username     string
+	LanguageCode string // telegram client language, example: "en"
+
+	// shared by reply keyboard request buttons
+	Phone     string
+	Latitude  float32
+	Longitude float32
+
+	server *Server
+
+	locker       sync.Mutex
+	readMessages int // number of messages already returned by WaitMessage
+	lastCallback int
+}
+
+// NewUser creates virtual user with private chat ID equal to user ID
+func (s *Server) NewUser(telegramUserID int64, firstName string) *User {
+	return &User{
+		ID:        telegramUserID,
+		FirstName: firstName,
+		server:    s,
+	}
+}
+
+func (u *User) sender() *tb.User {
+	return &tb.User{
+		ID:           u.ID,
+		FirstName:    u.FirstName,
+		Username:     u.Username,
+		LanguageCode: u.LanguageCode,
+	}
+}
+
+func (u *User) chat() *tb.Chat {
+	return &tb.Chat{
+		ID:        u.ID,
+		Type:      tb.ChatPrivate,
+		FirstName: u.FirstName,
+		Username:  u.Username,
+	}
+}
+
+// Send text message to bot
+func (u *User) Send(text string) {
+	msg := &tb.Message{
+		Sender:   u.sender(),
+		Chat:     u.chat(),
+		Text:     text,
+		Unixtime: time.Now().Unix(),
+	}
+	if strings.HasPrefix(text, "/") {
+		command := strings.SplitN(text, " ", 2)[0]
+		msg.Entities = tb.Entities{{
+			Type:   tb.EntityCommand,
+			Length: len(command),
+		}}
+	}
+
+	u.server.pushUpdate(tb.Update{Message: msg})
+}
+
+// Start sends /start command with optional deep link payload
+func (u *User) Start(payload string) {
+	if payload == "" {
+		u.Send("/start")
+		return
+	}
+	u.Send("/start " + payload)
+}
+
+// Press inline button with given text in the last message that contains it.
+// Returns callback ID
+func (u *User) Press(buttonText string) (string, error) {
+	messages := u.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		btn, isFound := messages[i].Button(buttonText)
+		if !isFound {
+			continue
+		}
+		if btn.Data == "" {
+			return "", fmt.Errorf("button %q is not a callback button", buttonText)
+		}
+
+		return u.sendCallback(messages[i], btn.Data), nil
+	}
+	return "", fmt.Errorf("button %q not found", buttonText)
+}
+
+// ReplyKeyboard returns reply keyboard shown to user. nil when it's removed
+func (u *User) ReplyKeyboard() [][]Button {
+	messages := u.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if len(messages[i].ReplyKeyboard) > 0 {
+			return messages[i].ReplyKeyboard
+		}
+		if messages[i].RemoveKeyboard {
+			return nil
+		}
+	}
+	return nil
+}
+
+// PressReply presses reply keyboard button: sends its text,
+// or user contact or location for request buttons
+func (u *User) PressReply(buttonText string) error {
+	keyboard := u.ReplyKeyboard()
+	btn, isFound := Message{ReplyKeyboard: keyboard}.ReplyButton(buttonText)
+	if !isFound {
+		return fmt.Errorf("reply button %q not found", buttonText)
+	}
+
+	msg := &tb.Message{
+		Sender:   u.sender(),
+		Chat:     u.chat(),
+		Unixtime: time.Now().Unix(),
+	}
+	switch {
+	default:
+		u.Send(btn.Text)
+		return nil
+	case btn.RequestContact:
+		msg.Contact = &tb.Contact{
+			PhoneNumber: u.Phone,
+			FirstName:   u.FirstName,
+			UserID:      u.ID,
+		}
+	case btn.RequestLocation:
+		msg.Location = &tb.Location{Lat: u.Latitude, Lng: u.Longitude}
+	}
+
+	u.server.pushUpdate(tb.Update{Message: msg})
+	return nil
+}
+
+func (u *User) sendCallback(msg Message, data string) string {
+	u.locker.Lock()
+	u.lastCallback++
+	callbackID := fmt.Sprintf("%v-%v", u.ID, u.lastCallback)
+	u.locker.Unlock()
+
+	u.server.pushUpdate(tb.Update{Callback: &tb.Callback{
+		ID:      callbackID,
+		Sender:  u.sender(),
+		Message: msg.toTelebot(),
+		Data:    data,
+	}})
+	return callbackID
+}
+
+// Messages returns all messages sent to user
+func (u *User) Messages() []Message {
+	var messages []Message
+	for _, msg := range u.server.Messages() {
+		if msg.ChatID == u.ID {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// WaitMessage waits for the next message sent to user.
+// Messages are returned in order they were sent
+func (u *User) WaitMessage(timeout time.Duration) (Message, error) {
+	u.locker.Lock()
+	defer u.locker.Unlock()
+
+	var msg Message
+	isReceived := u.server.waitFor(timeout, func() bool {
+		count := 0
+		for _, m := range u.server.messages {
+			if m.ChatID != u.ID {
+				continue
+			}
+			if count == u.readMessages {
+				msg = m
+				return true
+			}
+			count++
+		}
+		return false
+	})
+	if !isReceived {
+		return Message{}, fmt.Errorf("no message for user %v in %s", u.ID, timeout)
+	}
+
+	u.readMessages++
+	return msg, nil
+}
+
+// WaitCallbackAnswer waits until bot answers the callback query
+func (u *User) WaitCallbackAnswer(callbackID string, timeout time.Duration) error {
+	isAnswered := u.server.waitFor(timeout, func() bool {
+		_, isAnswered := u.server.callbacks[callbackID]
+		return isAnswered
+	})
+	if !isAnswered {
+		return fmt.Errorf("callback %q is not answered in %s", callbackID, timeout)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package tgfun
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// UserStore - funnel users storage backend
+type UserStore interface {
+	// returns nil when user not found
+	GetUser(telegramUserID int64) (*User, error)
+	// saves new user and sets its ID
+	CreateUser(user *User) error
+	// updates user found by telegram ID
+	UpdateUser(user User) error
+	// returns all users ordered by ID
+	ListUsers() ([]User, error)
+	MarkUserBlocked(telegramUserID int64) error
+}
+
+// MemoryUserStore - in-memory users storage, useful for tests
+type MemoryUserStore struct {
+	locker sync.RWMutex
+	lastID int64
+	users  map[int64]User // telegram ID -> user
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: map[int64]User{}}
+}
+
+func (s *MemoryUserStore) GetUser(telegramUserID int64) (*User, error) {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	user, isExists := s.users[telegramUserID]
+	if !isExists {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (s *MemoryUserStore) CreateUser(user *User) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if _, isExists := s.users[user.TelegramID]; isExists {
+		return errors.New("user already exists")
+	}
+
+	s.lastID++
+	user.ID = s.lastID
+	s.users[user.TelegramID] = *user
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateUser(user User) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	saved, isExists := s.users[user.TelegramID]
+	if !isExists {
+		return errors.New("user not found")
+	}
+
+	user.ID = saved.ID
+	s.users[user.TelegramID] = user
+	return nil
+}
+
+func (s *MemoryUserStore) ListUsers() ([]User, error) {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+func (s *MemoryUserStore) MarkUserBlocked(telegramUserID int64) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	user, isExists := s.users[telegramUserID]
+	if !isExists {
+		return nil
+	}
+
+	user.IsBlocked = true
+	s.users[telegramUserID] = user
+	return nil
+}
@@ -0,0 +1,96 @@
+package tgfun
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestMemoryUserStore(t *testing.T) {
+	// given
+	store := NewMemoryUserStore()
+	first := User{TelegramID: 100, Name: "first"}
+	second := User{TelegramID: 200, Name: "second"}
+
+	// when
+	require.NoError(t, store.CreateUser(&first))
+	require.NoError(t, store.CreateUser(&second))
+	require.NoError(t, store.MarkUserBlocked(200))
+	missing, err := store.GetUser(300)
+	require.NoError(t, err)
+	users, err := store.ListUsers()
+	require.NoError(t, err)
+
+	// then
+	assert.Nil(t, missing)
+	require.Len(t, users, 2)
+	assert.Equal(t, int64(1), users[0].ID)
+	assert.False(t, users[0].IsBlocked)
+	assert.Equal(t, int64(2), users[1].ID)
+	assert.True(t, users[1].IsBlocked)
+}
+
+func TestSQLDialectRebind(t *testing.T) {
+	// given
+	query := "UPDATE t SET name=?, tgname=? WHERE tid=?"
+
+	// when
+	postgresQuery := sqlDialectPostgres.rebind(query)
+	mysqlQuery := sqlDialectMySQL.rebind(query)
+
+	// then
+	assert.Equal(t, "UPDATE t SET name=$1, tgname=$2 WHERE tid=$3", postgresQuery)
+	assert.Equal(t, query, mysqlQuery)
+}
+
+func TestSQLDialectQuoteIdent(t *testing.T) {
+	assert.Equal(t, "`db`.`users`", sqlDialectMySQL.quoteIdent("db.users"))
+	assert.Equal(t, "`a``b`", sqlDialectMySQL.quoteIdent("a`b"))
+	assert.Equal(t, `"public"."users"`, sqlDialectPostgres.quoteIdent("public.users"))
+}
+
+func TestUsersFeatureTracksTouches(t *testing.T) {
+	// given
+	feature := UsersFeature{
+		Store:                 NewMemoryUserStore(),
+		ConversionAttribution: AttributionFirstTouch,
+	}
+	sender := &tb.User{ID: 100, FirstName: "John"}
+
+	// when
+	_, err := feature.getUserData(sender, UserPayload{UTMSource: "dzen", UTMCampaign: "org"})
+	require.NoError(t, err)
+	_, err = feature.getUserData(sender, UserPayload{UTMSource: "offer", UTMCampaign: "back", BackLinkEventID: "offer"})
+	require.NoError(t, err)
+	user, err := feature.getUserData(sender, UserPayload{UTMSource: "yandex", UTMCampaign: "search"})
+	require.NoError(t, err)
+	attributed, err := feature.attributePayload(100, UserPayload{})
+	require.NoError(t, err)
+
+	// then
+	assert.Equal(t, "dzen", user.FirstTouch.UTMSource)
+	assert.Equal(t, "yandex", user.LastTouch.UTMSource)
+	assert.False(t, user.LastTouch.At.IsZero())
+	assert.Equal(t, "dzen", attributed.UTMSource)
+	assert.Equal(t, "org", attributed.UTMCampaign)
+}
+
+func TestUsersFeatureUnblocksReturnedUser(t *testing.T) {
+	// given
+	feature := UsersFeature{Store: NewMemoryUserStore()}
+	sender := &tb.User{ID: 100, FirstName: "John"}
+	_, err := feature.getUserData(sender, UserPayload{})
+	require.NoError(t, err)
+	require.NoError(t, feature.Store.MarkUserBlocked(100))
+
+	// when
+	_, err = feature.getUserData(sender, UserPayload{})
+	require.NoError(t, err)
+
+	// then
+	user, err := feature.Store.GetUser(100)
+	require.NoError(t, err)
+	assert.False(t, user.IsBlocked)
+}
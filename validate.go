@@ -0,0 +1,464 @@
+package tgfun
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	swissknife "github.com/Sagleft/swiss-knife"
+)
+
+const (
+	maxMessageTextLength = 4096
+	maxCaptionLength     = 1024
+	maxCallbackDataLen   = 64
+)
+
+type ValidationSeverity string
+
+const (
+	ValidationSeverityError   ValidationSeverity = "error"
+	ValidationSeverityWarning ValidationSeverity = "warning"
+)
+
+// ValidationIssue - funnel script problem found by Validate
+type ValidationIssue struct {
+	Severity ValidationSeverity
+	EventID  string
+	Field    string // example: "message.buttons[1].nextID"
+	Message  string
+}
+
+func (i ValidationIssue) String() string {
+	if i.Field == "" {
+		return fmt.Sprintf("%s: event %q: %s", i.Severity, i.EventID, i.Message)
+	}
+	return fmt.Sprintf(
+		"%s: event %q: %s: %s",
+		i.Severity, i.EventID, i.Field, i.Message,
+	)
+}
+
+// ValidationResult - funnel script validation report
+type ValidationResult struct {
+	Errors   []ValidationIssue
+	Warnings []ValidationIssue
+}
+
+func (r ValidationResult) HasErrors() bool {
+	return len(r.Errors) > 0
+}
+
+// Err returns nil when there are no errors
+func (r ValidationResult) Err() error {
+	if !r.HasErrors() {
+		return nil
+	}
+
+	lines := make([]string, 0, len(r.Errors))
+	for _, issue := range r.Errors {
+		lines = append(lines, issue.String())
+	}
+	return fmt.Errorf("invalid funnel script:\n%s", strings.Join(lines, "\n"))
+}
+
+func (r *ValidationResult) addError(eventID, field, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, ValidationIssue{
+		Severity: ValidationSeverityError,
+		EventID:  eventID,
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *ValidationResult) addWarning(eventID, field, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, ValidationIssue{
+		Severity: ValidationSeverityWarning,
+		EventID:  eventID,
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate - check funnel script links, media files and telegram limits
+// without sending anything
+func (f *Funnel) Validate() ValidationResult {
+	return f.validateScript(f.getScript())
+}
+
+// validate script with funnel settings before it's used
+func (f *Funnel) validateScript(script FunnelScript) ValidationResult {
+	snapshot := &Funnel{
+		Data:         f.Data,
+		Script:       script,
+		features:     f.features,
+		resStore:     f.resStore,
+		templateVars: f.templateVars,
+	}
+	return snapshot.validate()
+}
+
+func (f *Funnel) validate() ValidationResult {
+	r := ValidationResult{}
+
+	if _, isExists := f.Script[startMessageCode]; !isExists {
+		r.addError(startMessageCode, "", "start message not found in script")
+	}
+
+	f.Data.Localization.validate(&r)
+	f.validateCacheWarmUp(&r)
+	if !f.Data.Navigation.isValid() {
+		r.addError("", "navigation", "unknown navigation mode %q", f.Data.Navigation)
+	}
+
+	for _, eventID := range f.Script.eventIDs() {
+		event := f.Script[eventID]
+
+		f.validateButtons(&r, eventID, event.Message)
+		validateNavigation(&r, eventID, event.Message)
+		validateKeyboard(&r, eventID, event.Message)
+		f.validateTemplates(&r, eventID, event.Message)
+		f.validateLocalization(&r, eventID, event.Message)
+		f.validateLocker(&r, eventID, event.SubscriptionLocker)
+		f.validateFollowUps(&r, eventID, event.FollowUps)
+		f.validateMedia(&r, eventID, event.Message)
+		validateTextLength(&r, eventID, event.Message)
+	}
+
+	for _, text := range f.Script.findReplyButtonConflicts() {
+		r.addError("", "", "reply button %q leads to different events", text)
+	}
+
+	f.validateUserInputLinks(&r)
+	f.validateReachability(&r)
+	return r
+}
+
+// returns sorted event IDs
+func (s FunnelScript) eventIDs() []string {
+	ids := make([]string, 0, len(s))
+	for eventID := range s {
+		ids = append(ids, eventID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *Funnel) validateButtons(r *ValidationResult, eventID string, msg EventMessage) {
+	if msg.ButtonsSplit < 0 {
+		r.addError(eventID, "message.buttonsSplit", "must not be negative")
+	}
+
+	for i, btn := range msg.Buttons {
+		field := fmt.Sprintf("message.buttons[%v]", i)
+
+		if btn.Text == "" {
+			r.addError(eventID, field+".text", "button text is empty")
+		}
+
+		switch {
+		case btn.URL != "" && btn.NextMessageID != "":
+			r.addError(
+				eventID, field,
+				"both url and nextID are set, nextID %q will be ignored",
+				btn.NextMessageID,
+			)
+		case btn.URL == "" && btn.NextMessageID == "":
+			r.addError(eventID, field, "neither url nor nextID is set")
+		case btn.URL != "":
+			if btn.Param != "" {
+				r.addWarning(eventID, field+".param", "param is ignored for url button")
+			}
+			continue
+		}
+
+		if _, isExists := f.Script[btn.NextMessageID]; !isExists {
+			r.addError(
+				eventID, field+".nextID",
+				"next event %q not found in script", btn.NextMessageID,
+			)
+		}
+		// telebot encodes data button as "\f" + unique
+		if len(btn.NextMessageID)+1 > maxCallbackDataLen {
+			r.addError(
+				eventID, field+".nextID",
+				"event ID is longer than %v bytes of callback data",
+				maxCallbackDataLen-1,
+			)
+		} else if btn.Param != "" &&
+			len(btn.NextMessageID)+len(buttonParamHashPrefix)+buttonParamHashLen+2 > maxCallbackDataLen {
+			r.addError(
+				eventID, field+".param",
+				"event ID %q is too long to pass button param", btn.NextMessageID,
+			)
+		}
+	}
+}
+
+func validateNavigation(r *ValidationResult, eventID string, msg EventMessage) {
+	if !msg.Navigation.isValid() {
+		r.addError(
+			eventID, "message.navigation",
+			"unknown navigation mode %q", msg.Navigation,
+		)
+	}
+}
+
+func validateKeyboard(r *ValidationResult, eventID string, msg EventMessage) {
+	switch msg.Keyboard {
+	default:
+		r.addError(eventID, "message.keyboard", "unknown keyboard %q", msg.Keyboard)
+		return
+	case KeyboardRemove:
+		if len(msg.Buttons) > 0 {
+			r.addWarning(eventID, "message.buttons", "buttons are ignored when keyboard is removed")
+		}
+		return
+	case KeyboardReply, KeyboardInline:
+	}
+
+	for i, btn := range msg.Buttons {
+		field := fmt.Sprintf("message.buttons[%v]", i)
+
+		if msg.Keyboard == KeyboardInline {
+			if btn.RequestContact || btn.RequestLocation {
+				r.addError(eventID, field, "contact and location requests are only for reply keyboard")
+			}
+			continue
+		}
+
+		if btn.URL != "" {
+			r.addError(eventID, field+".url", "url buttons are only for inline keyboard")
+		}
+		if btn.RequestContact && btn.RequestLocation {
+			r.addError(eventID, field, "both contact and location are requested")
+		}
+	}
+}
+
+func (f *Funnel) validateTemplates(r *ValidationResult, eventID string, msg EventMessage) {
+	fields := map[string]string{"message.text": msg.Text}
+	for i, btn := range msg.Buttons {
+		fields[fmt.Sprintf("message.buttons[%v].text", i)] = btn.Text
+		fields[fmt.Sprintf("message.buttons[%v].url", i)] = btn.URL
+	}
+	for i, item := range msg.Album {
+		fields[fmt.Sprintf("message.album[%v].caption", i)] = item.Caption
+	}
+
+	for _, field := range sortedKeys(fields) {
+		for _, name := range getTemplateVars(fields[field]) {
+			_, isCustom := f.templateVars[name]
+			if isCustom || builtinTemplateVars[name] ||
+				strings.HasPrefix(name, sessionTemplatePrefix) {
+				continue
+			}
+			r.addWarning(eventID, field, "unknown template variable %q", name)
+		}
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *Funnel) validateLocker(r *ValidationResult, eventID string, locker EventLocker) {
+	if !locker.Enabled {
+		return
+	}
+
+	if locker.ChatID == 0 {
+		r.addError(eventID, "locker.chatID", "locker chat ID is not set")
+	}
+
+	if locker.LockerMessageID == "" {
+		r.addError(eventID, "locker.lockerMessageID", "locker event ID is not set")
+		return
+	}
+	if _, isExists := f.Script[locker.LockerMessageID]; !isExists {
+		r.addError(
+			eventID, "locker.lockerMessageID",
+			"locker event %q not found in script", locker.LockerMessageID,
+		)
+	}
+}
+
+func (f *Funnel) validateFollowUps(r *ValidationResult, eventID string, followUps []FollowUp) {
+	if len(followUps) > 0 && !f.features.IsDripFeatureActive() {
+		r.addWarning(eventID, "followUps", "drip feature is disabled, follow-ups will not be sent")
+	}
+
+	for i, followUp := range followUps {
+		field := fmt.Sprintf("followUps[%v]", i)
+
+		if _, isExists := f.Script[followUp.EventID]; !isExists {
+			r.addError(
+				eventID, field+".eventID",
+				"follow-up event %q not found in script", followUp.EventID,
+			)
+		}
+
+		delay, err := followUp.getDelay()
+		if err != nil {
+			r.addError(eventID, field+".delay", err.Error())
+		} else if delay <= 0 {
+			r.addError(eventID, field+".delay", "delay must be positive")
+		}
+
+		for j, cancelEventID := range followUp.CancelOnEvents {
+			if _, isExists := f.Script[cancelEventID]; !isExists {
+				r.addError(
+					eventID, fmt.Sprintf("%s.cancelOn[%v]", field, j),
+					"event %q not found in script", cancelEventID,
+				)
+			}
+		}
+	}
+}
+
+func (f *Funnel) validateMedia(r *ValidationResult, eventID string, msg EventMessage) {
+	if msg.Image == "parametric" {
+		if !f.features.IsUserInputFeatureActive() {
+			r.addError(
+				eventID, "message.image",
+				"parametric image requires user input feature",
+			)
+		}
+	} else if msg.Image != "" && !strings.Contains(msg.Image, "http") {
+		f.validateLocalFile(r, eventID, "message.image", msg.Image)
+	}
+
+	f.validateLocalFile(r, eventID, "message.file.path", msg.File.Path)
+	f.validateLocalFile(r, eventID, "message.file.preview", msg.File.PreviewImagePath)
+	f.validateLocalFile(r, eventID, "message.audio.path", msg.Audio.Path)
+	f.validateLocalFile(r, eventID, "message.video.path", msg.Video.Path)
+	f.validateLocalFile(r, eventID, "message.video.preview", msg.Video.PreviewImagePath)
+	f.validateLocalFile(r, eventID, "message.voice.path", msg.Voice.Path)
+	f.validateLocalFile(r, eventID, "message.videoNote.path", msg.VideoNote.Path)
+	f.validateLocalFile(r, eventID, "message.sticker.path", msg.Sticker.Path)
+	if !isExternalFile(msg.Animation.Path) {
+		f.validateLocalFile(r, eventID, "message.animation.path", msg.Animation.Path)
+	}
+	f.validateAlbum(r, eventID, msg)
+}
+
+func (f *Funnel) validateLocalFile(r *ValidationResult, eventID, field, localPath string) {
+	if localPath == "" {
+		return
+	}
+
+	filePath := getFilePath(localPath, f.Data.ImageRoot)
+	if !swissknife.IsFileExists(filePath) {
+		r.addError(eventID, field, "file %q not found", filePath)
+	}
+}
+
+func validateTextLength(r *ValidationResult, eventID string, msg EventMessage) {
+	if msg.Callback != nil || msg.CallbackWithParam != nil {
+		return // message is built at runtime
+	}
+
+	limit := maxMessageTextLength
+	messageType := getMessageType(msg)
+	switch messageType {
+	case MessageTypeAlbum:
+		if isAlbumWithFollowUp(msg) {
+			messageType = MessageTypeText
+		}
+	case MessageTypeVideoNote, MessageTypeSticker:
+		if msg.Text != "" {
+			r.addWarning(eventID, "message.text", "text is not shown with %s", messageType)
+		}
+		return
+	}
+	if messageType != MessageTypeText {
+		limit = maxCaptionLength
+	}
+
+	if length := utf8.RuneCountInString(msg.Text); length > limit {
+		r.addError(
+			eventID, "message.text",
+			"text length %v exceeds telegram limit of %v characters",
+			length, limit,
+		)
+	}
+}
+
+func (f *Funnel) validateUserInputLinks(r *ValidationResult) {
+	if !f.features.IsUserInputFeatureActive() {
+		return
+	}
+
+	links := []struct {
+		field   string
+		eventID string
+	}{
+		{"userInput.inputVerifiedEventID", f.features.UserInput.InputVerifiedEventID},
+		{"userInput.invalidFormatEventID", f.features.UserInput.InvalidFormatEventID},
+	}
+	for _, link := range links {
+		if link.eventID == "" {
+			r.addWarning("", link.field, "event ID is not set, user input will be ignored")
+			continue
+		}
+
+		if _, isExists := f.Script[link.eventID]; !isExists {
+			r.addError("", link.field, "event %q not found in script", link.eventID)
+		}
+	}
+}
+
+// events that are not linked from /start are still available by typing
+// their ID, so they are reported as warnings only
+func (f *Funnel) validateReachability(r *ValidationResult) {
+	reached := map[string]bool{}
+	queue := []string{}
+
+	visit := func(eventID string) {
+		if _, isExists := f.Script[eventID]; !isExists || reached[eventID] {
+			return
+		}
+		reached[eventID] = true
+		queue = append(queue, eventID)
+	}
+
+	for _, eventID := range f.Script.eventIDs() {
+		// commands are entry points as well
+		if strings.HasPrefix(eventID, "/") {
+			visit(eventID)
+		}
+	}
+	if f.features.IsUserInputFeatureActive() {
+		visit(f.features.UserInput.InputVerifiedEventID)
+		visit(f.features.UserInput.InvalidFormatEventID)
+	}
+
+	for len(queue) > 0 {
+		event := f.Script[queue[0]]
+		queue = queue[1:]
+
+		for _, btn := range event.Message.Buttons {
+			if btn.URL == "" {
+				visit(btn.NextMessageID)
+			}
+		}
+		if event.SubscriptionLocker.Enabled {
+			visit(event.SubscriptionLocker.LockerMessageID)
+		}
+		for _, followUp := range event.FollowUps {
+			visit(followUp.EventID)
+		}
+	}
+
+	for _, eventID := range f.Script.eventIDs() {
+		if !reached[eventID] {
+			r.addWarning(eventID, "", "event is not reachable from /start or commands")
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package tgfun
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+	"github.com/test-go/testify/require"
+)
+
+func TestValidateValidScript(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{
+		"/start": {Message: EventMessage{
+			Text:    "hello",
+			Buttons: []MessageButton{{Text: "next", NextMessageID: "offer"}},
+		}},
+		"offer": {Message: EventMessage{
+			Text:    "offer",
+			Buttons: []MessageButton{{Text: "site", URL: "https://example.com"}},
+		}},
+	})
+
+	// when
+	result := f.Validate()
+
+	// then
+	assert.False(t, result.HasErrors())
+	assert.Empty(t, result.Warnings)
+	assert.NoError(t, result.Err())
+}
+
+func TestValidateDanglingLinks(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{
+		"/start": {
+			Message: EventMessage{
+				Text: "hello",
+				Buttons: []MessageButton{
+					{Text: "next", NextMessageID: "missing"},
+					{Text: "both", NextMessageID: "/start", URL: "https://example.com"},
+				},
+			},
+			SubscriptionLocker: EventLocker{
+				Enabled:         true,
+				ChatID:          -100,
+				LockerMessageID: "subscribe",
+			},
+		},
+	})
+
+	// when
+	result := f.Validate()
+
+	// then
+	require.Len(t, result.Errors, 3)
+	assert.Equal(t, "message.buttons[0].nextID", result.Errors[0].Field)
+	assert.Equal(t, "message.buttons[1]", result.Errors[1].Field)
+	assert.Equal(t, "locker.lockerMessageID", result.Errors[2].Field)
+}
+
+func TestValidateMissingStartAndMedia(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{ImageRoot: t.TempDir()}, FunnelScript{
+		"offer": {Message: EventMessage{
+			Text:  strings.Repeat("a", maxCaptionLength+1),
+			Video: VideoData{Path: "missing.mp4"},
+		}},
+	})
+
+	// when
+	result := f.Validate()
+
+	// then
+	require.Len(t, result.Errors, 3)
+	assert.Equal(t, startMessageCode, result.Errors[0].EventID)
+	assert.Equal(t, "message.video.path", result.Errors[1].Field)
+	assert.Equal(t, "message.text", result.Errors[2].Field)
+
+	require.Len(t, result.Warnings, 1)
+	assert.Equal(t, "offer", result.Warnings[0].EventID)
+}
+
+func TestValidateNavigationMode(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{Navigation: "replace"}, FunnelScript{
+		"/start": {Message: EventMessage{Text: "hello", Navigation: "inplace"}},
+	})
+
+	// when
+	result := f.Validate()
+
+	// then
+	require.Len(t, result.Errors, 2)
+	assert.Equal(t, "navigation", result.Errors[0].Field)
+	assert.Equal(t, "message.navigation", result.Errors[1].Field)
+}
+
+func TestValidateReplyKeyboard(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{}, FunnelScript{
+		"/start": {Message: EventMessage{
+			Text:     "hello",
+			Keyboard: KeyboardReply,
+			Buttons: []MessageButton{
+				{Text: "next", NextMessageID: "first"},
+				{Text: "site", URL: "https://example.com"},
+			},
+		}},
+		"first": {Message: EventMessage{
+			Text:     "first",
+			Keyboard: KeyboardReply,
+			Buttons:  []MessageButton{{Text: "next", NextMessageID: "second"}},
+		}},
+		"second": {Message: EventMessage{
+			Text:    "second",
+			Buttons: []MessageButton{{Text: "phone", NextMessageID: "first", RequestContact: true}},
+		}},
+	})
+
+	// when
+	result := f.Validate()
+
+	// then
+	require.Len(t, result.Errors, 3)
+	assert.Equal(t, "message.buttons[1].url", result.Errors[0].Field)
+	assert.Equal(t, "second", result.Errors[1].EventID)
+	assert.Contains(t, result.Errors[2].Message, `reply button "next"`)
+}
+
+func TestValidateAlbum(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{ImageRoot: t.TempDir()}, FunnelScript{
+		"/start": {Message: EventMessage{
+			Album: []AlbumItem{
+				{Image: "https://example.com/1.jpg"},
+				{Video: "missing.mp4"},
+				{},
+			},
+			Buttons: []MessageButton{{Text: "site", URL: "https://example.com"}},
+		}},
+	})
+
+	// when
+	result := f.Validate()
+
+	// then
+	require.Len(t, result.Errors, 3)
+	assert.Equal(t, "message.text", result.Errors[0].Field)
+	assert.Equal(t, "message.album[1].video", result.Errors[1].Field)
+	assert.Equal(t, "message.album[2]", result.Errors[2].Field)
+}
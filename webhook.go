@@ -0,0 +1,183 @@
+package tgfun
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+	longPollerTimeout   = 10 * time.Second
+)
+
+var webhookSecretRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// WebhookData - webhook mode settings. Long polling is used when PublicURL is not set
+type WebhookData struct {
+	PublicURL   string `json:"publicURL"`   // HTTPS URL registered in telegram
+	Listen      string `json:"listen"`      // optional. example: ":8443". mount Funnel.WebhookHandler when empty
+	SecretToken string `json:"secretToken"` // optional. checked in every update request
+	TLSCertPath string `json:"tlsCert"`     // optional. also uploaded to telegram for self-signed certs
+	TLSKeyPath  string `json:"tlsKey"`      // optional
+}
+
+func (d WebhookData) IsEnabled() bool {
+	return d.PublicURL != ""
+}
+
+func (d WebhookData) isTLSEnabled() bool {
+	return d.TLSCertPath != "" && d.TLSKeyPath != ""
+}
+
+func (d WebhookData) check() error {
+	if d.SecretToken != "" && !webhookSecretRegexp.MatchString(d.SecretToken) {
+		return errors.New("webhook secret token must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	if (d.TLSCertPath == "") != (d.TLSKeyPath == "") {
+		return errors.New("both webhook TLS cert and key must be set")
+	}
+	return nil
+}
+
+func (f *Funnel) getPoller() (tb.Poller, error) {
+	if !f.Data.Webhook.IsEnabled() {
+		return &tb.LongPoller{Timeout: longPollerTimeout}, nil
+	}
+
+	if err := f.Data.Webhook.check(); err != nil {
+		return nil, fmt.Errorf("check webhook data: %w", err)
+	}
+	return f.getWebhook(), nil
+}
+
+// telebot webhook poller is not used: it closes stop channel, that is
+// closed by Bot.Start as well, and blocks requests received out of polling
+func (f *Funnel) getWebhook() *webhookPoller {
+	f.webhookOnce.Do(func() {
+		f.webhook = &webhookPoller{webhook: &tb.Webhook{
+			SecretToken: f.Data.Webhook.SecretToken,
+			Endpoint: &tb.WebhookEndpoint{
+				PublicURL: f.Data.Webhook.PublicURL,
+				Cert:      f.Data.Webhook.TLSCertPath,
+			},
+		}}
+	})
+	return f.webhook
+}
+
+// webhookPoller - registers webhook and passes updates
+// from WebhookHandler to the bot while polling is active
+type webhookPoller struct {
+	webhook *tb.Webhook // setWebhook params
+
+	locker sync.RWMutex
+	dest   chan tb.Update // nil when polling is not active
+	stop   chan struct{}
+}
+
+func (p *webhookPoller) Poll(b *tb.Bot, dest chan tb.Update, stop chan struct{}) {
+	if err := b.SetWebhook(p.webhook); err != nil {
+		b.OnError(fmt.Errorf("set webhook: %w", err), nil)
+	}
+
+	p.locker.Lock()
+	p.dest = dest
+	p.stop = stop
+	p.locker.Unlock()
+
+	// stop is closed by Bot.Start
+	<-stop
+
+	p.locker.Lock()
+	p.dest = nil
+	p.stop = nil
+	p.locker.Unlock()
+}
+
+// returns false when polling is not active
+func (p *webhookPoller) push(update tb.Update) bool {
+	p.locker.RLock()
+	dest, stop := p.dest, p.stop
+	p.locker.RUnlock()
+
+	if dest == nil {
+		return false
+	}
+	select {
+	case <-stop:
+		return false
+	case dest <- update:
+		return true
+	}
+}
+
+// WebhookHandler - http handler for telegram updates.
+// Mount it into your own http.ServeMux when WebhookData.Listen is empty
+func (f *Funnel) WebhookHandler() http.Handler {
+	return http.HandlerFunc(f.handleWebhookRequest)
+}
+
+func (f *Funnel) handleWebhookRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	secretToken := f.Data.Webhook.SecretToken
+	if secretToken != "" && subtle.ConstantTimeCompare(
+		[]byte(r.Header.Get(webhookSecretHeader)),
+		[]byte(secretToken),
+	) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var update tb.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !f.getWebhook().push(update) {
+		// telegram retries the update later
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+}
+
+func (f *Funnel) startWebhookListener() {
+	if !f.Data.Webhook.IsEnabled() || f.Data.Webhook.Listen == "" {
+		return
+	}
+
+	f.webhookServer = &http.Server{
+		Addr:    f.Data.Webhook.Listen,
+		Handler: f.WebhookHandler(),
+	}
+
+	go func(s *http.Server) {
+		var err error
+		if f.Data.Webhook.isTLSEnabled() {
+			err = s.ListenAndServeTLS(
+				f.Data.Webhook.TLSCertPath,
+				f.Data.Webhook.TLSKeyPath,
+			)
+		} else {
+			err = s.ListenAndServe()
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			f.errReporter.report(ErrorEvent{
+				Stage: ErrorStageHandle,
+				Err:   fmt.Errorf("webhook listener: %w", err),
+			})
+		}
+	}(f.webhookServer)
+}
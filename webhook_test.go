@@ -0,0 +1,63 @@
+package tgfun
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestWebhookHandlerRejectsInvalidSecret(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{Webhook: WebhookData{
+		PublicURL:   "https://example.com/bot",
+		SecretToken: "secret",
+	}}, FunnelScript{})
+
+	req := httptest.NewRequest(http.MethodPost, "/bot", strings.NewReader("{}"))
+	req.Header.Set(webhookSecretHeader, "wrong")
+	w := httptest.NewRecorder()
+
+	// when
+	f.WebhookHandler().ServeHTTP(w, req)
+
+	// then
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestWebhookDataCheck(t *testing.T) {
+	// given
+	data := WebhookData{
+		PublicURL:   "https://example.com/bot",
+		SecretToken: "not a valid token!",
+	}
+
+	// when
+	err := data.check()
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestGetWebhookConcurrent(t *testing.T) {
+	// given
+	f := NewFunnel(FunnelData{Webhook: WebhookData{
+		PublicURL: "https://example.com/bot",
+	}}, FunnelScript{})
+
+	// when
+	webhooks := make(chan *webhookPoller, 10)
+	for i := 0; i < cap(webhooks); i++ {
+		go func() {
+			webhooks <- f.getWebhook()
+		}()
+	}
+
+	// then
+	first := <-webhooks
+	for i := 1; i < cap(webhooks); i++ {
+		assert.True(t, first == <-webhooks)
+	}
+}